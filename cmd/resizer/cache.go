@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/esavich/otus_project/internal/config"
+	"github.com/esavich/otus_project/internal/diskcache"
+)
+
+const cacheUsage = `Usage: resizer cache command [flags]
+
+Inspects and repairs the cache directory of a stopped server, results are printed as json.
+
+Commands:
+  stats    count entries, bytes and orphaned files
+  list     list entries with their source url and size, from the oldest to the newest
+  verify   check images against stored checksums, -remove deletes corrupted entries
+  prune    remove the oldest entries until images take at most -budget bytes
+  orphans  list files that don't belong to any entry, -remove deletes them
+  export   write entries to the -out archive, .tar, .tar.gz, .tgz or .tar.zst
+  import   load entries from the -in archive, corrupted entries are skipped
+`
+
+// runCache runs a cache maintenance command, it fails when verify finds corrupted entries.
+func runCache(args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" {
+		fmt.Fprint(os.Stderr, cacheUsage)
+		return 2
+	}
+	command := args[0]
+
+	fs := flag.NewFlagSet("cache "+command, flag.ContinueOnError)
+	dir := fs.String("dir", "", "cache directory, CACHE_PATH by default")
+	configFile := fs.String("config", "", "config file of the server")
+	var flags cacheFlags
+	switch command {
+	case "stats", "list":
+	case "verify":
+		flags.remove = fs.Bool("remove", false, "delete corrupted entries")
+	case "orphans":
+		flags.remove = fs.Bool("remove", false, "delete orphaned files")
+	case "prune":
+		flags.budget = fs.Int64("budget", -1, "bytes images may take")
+	case "export":
+		flags.archive = fs.String("out", "", "archive to write")
+	case "import":
+		flags.archive = fs.String("in", "", "archive to read")
+		flags.fanOut = fs.Int("fan-out", -1, "directory levels of imported files, CACHE_FAN_OUT by default")
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown cache command: %s\n\n%s", command, cacheUsage)
+		return 2
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if flags.budget != nil && *flags.budget < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -budget is required")
+		return 2
+	}
+	if flags.archive != nil && *flags.archive == "" {
+		fmt.Fprintln(os.Stderr, "Error: archive path is required")
+		return 2
+	}
+	if *dir == "" || (flags.fanOut != nil && *flags.fanOut < 0) {
+		// the same .env and config file as the server
+		cfg, err := loadConfig(*configFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error loading config:", err)
+			return 1
+		}
+		if *dir == "" {
+			*dir = cfg.Cache.Path
+		}
+		if flags.fanOut != nil && *flags.fanOut < 0 {
+			*flags.fanOut = cfg.Cache.FanOut
+		}
+	}
+
+	result, failed, err := cacheCommand(command, *dir, flags)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	if err := writeJSON("-", result); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	if failed {
+		return 1
+	}
+
+	return 0
+}
+
+// cacheFlags are flags of cache commands, nil when the command has no such flag.
+type cacheFlags struct {
+	budget  *int64
+	remove  *bool
+	archive *string
+	fanOut  *int
+}
+
+func cacheCommand(command, dir string, flags cacheFlags) (any, bool, error) {
+	switch command {
+	case "stats":
+		listing, err := diskcache.List(dir)
+		return listing.Stats(), false, err
+	case "list":
+		listing, err := diskcache.List(dir)
+		return listing.Entries, false, err
+	case "verify":
+		corrupted, err := diskcache.Verify(dir)
+		if err != nil || !*flags.remove {
+			return map[string]any{"corrupted": corrupted}, len(corrupted) > 0, err
+		}
+		for _, c := range corrupted {
+			if err := c.Remove(); err != nil {
+				return nil, true, err
+			}
+		}
+		return map[string]any{"corrupted": corrupted, "removed": len(corrupted)}, false, nil
+	case "prune":
+		removed, err := diskcache.Prune(dir, *flags.budget)
+		var freed int64
+		for _, e := range removed {
+			freed += e.Size
+		}
+		return map[string]any{"removed": removed, "freed": freed}, false, err
+	case "orphans":
+		if *flags.remove {
+			removed, err := diskcache.RemoveOrphans(dir)
+			return map[string]any{"removed": removed}, false, err
+		}
+		listing, err := diskcache.List(dir)
+		return map[string]any{"orphans": listing.Orphans}, false, err
+	case "export":
+		stats, err := diskcache.ExportFile(dir, *flags.archive)
+		return stats, false, err
+	case "import":
+		stats, err := diskcache.ImportFile(dir, *flags.fanOut, *flags.archive)
+		return stats, false, err
+	}
+
+	return nil, false, fmt.Errorf("unknown cache command: %s", command)
+}
+
+// loadConfig loads the server config for commands, from the config file when it is set.
+func loadConfig(file string) (*config.Config, error) {
+	var args []string
+	if file != "" {
+		args = []string{"-config", file}
+	}
+
+	return config.Load(args)
+}
@@ -2,45 +2,152 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
+	"github.com/esavich/otus_project/internal/apikey"
 	"github.com/esavich/otus_project/internal/config"
 	"github.com/esavich/otus_project/internal/diskcache"
 	"github.com/esavich/otus_project/internal/downloader"
 	"github.com/esavich/otus_project/internal/logger"
+	"github.com/esavich/otus_project/internal/metrics"
+	"github.com/esavich/otus_project/internal/ratelimit"
+	"github.com/esavich/otus_project/internal/reload"
 	"github.com/esavich/otus_project/internal/resizer"
 	"github.com/esavich/otus_project/internal/server"
 	"github.com/esavich/otus_project/internal/service"
+	"github.com/esavich/otus_project/internal/tracing"
+	"github.com/esavich/otus_project/internal/workerpool"
 )
 
 func main() {
-	cfg, err := config.Load()
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
+
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
 		fmt.Println("Error loading config:", err)
-		return
+		os.Exit(1)
 	}
 	logger.SetupLogger(cfg)
-	slog.Debug(fmt.Sprintf("config: %+v", cfg))
+	slog.Debug(fmt.Sprintf("config: %+v", cfg.Redacted()))
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL)
 	defer cancel()
 
+	shutdownTracing, err := tracing.Setup(ctx, cfg)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error setting up tracing: %s", err))
+		return
+	}
+	defer func() {
+		err := shutdownTracing(context.Background())
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error shutting down tracing: %s", err))
+		}
+	}()
+
+	var accounting *apikey.Accounting
+	if len(cfg.APIKeys.Keys) > 0 {
+		accounting, err = newAccounting(cfg.APIKeys)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error setting up api keys: %s", err))
+			return
+		}
+		go accounting.SaveEvery(ctx, cfg.APIKeys.UsageFlushInterval)
+	}
+
+	var limits rateLimits
+	if cfg.Limits.Enabled {
+		limits, err = newRateLimits(cfg.Limits, accounting)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error setting up rate limits: %s", err))
+			return
+		}
+	}
+
 	// main dependencies
 	// u can use
-	imageService := service.NewSimpleImageService(
-		downloader.NewDownloader(cfg.App.DownloadTimeout),
+	pool := workerpool.New(cfg.Resize.Workers, cfg.Resize.QueueSize)
+	defer pool.Close()
+
+	dl := newDownloader(cfg)
+	var imageService service.ImageGetter = service.NewSimpleImageService(
+		dl,
 		resizer.NewResizer(),
+		pool,
 	)
-	dc, err := diskcache.NewDiskCacheWrapper(cfg.Cache.MaxItems, cfg.Cache.Path)
+	policy := service.WithCachePolicy(cachePolicy(*cfg))
+	rl := &reloader{args: os.Args[1:], current: cfg, downloader: dl, limits: limits}
+	var negativeService *service.NegativeCachedImageService
+	if cfg.Cache.NegativeTTL > 0 {
+		// permanent errors (404, broken image) are always cached,
+		// transient ones (timeouts, 5xx) only when explicitly enabled
+		cacheable := downloader.IsPermanent
+		if cfg.Cache.NegativeTransient {
+			cacheable = func(err error) bool {
+				return !errors.Is(err, workerpool.ErrQueueFull) && !errors.Is(err, workerpool.ErrClosed)
+			}
+		}
+		negativeService = service.NewNegativeCachedImageService(
+			imageService,
+			cfg.Cache.NegativeMaxItems,
+			cfg.Cache.NegativeTTL,
+			cacheable,
+			policy,
+		)
+		rl.policies = append(rl.policies, negativeService)
+		imageService = negativeService
+	}
+	dc, err := diskcache.NewDiskCacheWrapper(cfg.Cache.MaxItems, cfg.Cache.Path, cacheOptions(cfg)...)
 	if err != nil {
 		slog.Error(fmt.Sprintf("Error creating disk cache: %s", err))
 		return
 	}
-	cachedService := service.NewCachedImageService(imageService, dc)
+	if cfg.Cache.SeedPath != "" && dc.Stats().Items == 0 {
+		// a cold start is better than no start, seed errors are not fatal
+		stats, err := dc.ImportFile(ctx, cfg.Cache.SeedPath)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error seeding cache from %s after %d images: %s", cfg.Cache.SeedPath, stats.Entries, err))
+		} else {
+			slog.Info(fmt.Sprintf("Seeded cache with %d images, %d corrupted skipped", stats.Entries, stats.Skipped))
+		}
+	}
+	cachedOpts := []service.Option{policy}
+	if limits.miss != nil {
+		cachedOpts = append(cachedOpts, service.WithMissLimiter(limits.miss))
+	}
+	cachedService := service.NewCachedImageService(imageService, dc, cachedOpts...)
+	rl.policies = append(rl.policies, cachedService)
+
+	metrics.RegisterCache(dc)
+	metrics.RegisterWorkerQueue(pool)
 
-	srv := server.NewServer(cfg, cachedService)
+	serverOpts := []server.Option{server.WithCacheAdmin(dc)}
+	if negativeService != nil {
+		serverOpts = append(serverOpts, server.WithNegativeCache(negativeService))
+	}
+	if limits.key != nil {
+		serverOpts = append(serverOpts, server.WithRateLimits(limits.key, limits.routes))
+	}
+	if accounting != nil {
+		serverOpts = append(serverOpts, server.WithAPIKeys(accounting))
+	}
+	srv := server.NewServer(cfg, cachedService, serverOpts...)
+	rl.server = srv
+	go reload.Watch(ctx, []string{cfg.File, dotEnvFile}, cfg.App.ReloadInterval, rl.reload)
 
 	go func() {
 		slog.Debug("Starting server")
@@ -54,11 +161,168 @@ func main() {
 
 	<-ctx.Done()
 	slog.Info("Received shutdown signal, shutting down...")
-	err = dc.ClearDiskCache()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+	defer shutdownCancel()
+	err = srv.Shutdown(shutdownCtx)
 	if err != nil {
-		slog.Error(fmt.Sprintf("Error clearing disk cache: %s", err))
+		slog.Error(fmt.Sprintf("Error shutting down server: %s", err))
+	}
+	if accounting != nil {
+		err = accounting.Save()
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error saving api key usage: %s", err))
+		}
+	}
+	if !dc.Persistent() {
+		err = dc.ClearDiskCache()
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error clearing disk cache: %s", err))
+		}
+		slog.Info("Cache cleared")
 	}
-	slog.Info("Cache cleared")
 
 	cancel()
 }
+
+func cacheOptions(cfg *config.Config) []diskcache.Option {
+	opts := []diskcache.Option{diskcache.WithFanOut(cfg.Cache.FanOut)}
+	if cfg.Cache.Persistent {
+		opts = append(opts, diskcache.WithPersistence())
+	}
+
+	return opts
+}
+
+func newDownloader(cfg *config.Config) *downloader.Downloader {
+	return downloader.NewDownloader(
+		cfg.App.DownloadTimeout,
+		downloader.WithRetry(downloader.RetryPolicy{
+			MaxRetries: cfg.Download.Retries,
+			BaseDelay:  cfg.Download.RetryBaseDelay,
+			MaxDelay:   cfg.Download.RetryMaxDelay,
+		}),
+		downloader.WithCircuitBreaker(cfg.Download.BreakerThreshold, cfg.Download.BreakerCooldown),
+		downloader.WithTransport(downloader.TransportOptions{
+			MaxConnsPerHost:       cfg.Download.MaxConnsPerHost,
+			MaxIdleConns:          cfg.Download.MaxIdleConns,
+			MaxIdleConnsPerHost:   cfg.Download.MaxIdleConnsPerHost,
+			IdleConnTimeout:       cfg.Download.IdleConnTimeout,
+			KeepAlive:             cfg.Download.KeepAlive,
+			DialTimeout:           cfg.Download.DialTimeout,
+			TLSHandshakeTimeout:   cfg.Download.TLSHandshakeTimeout,
+			ResponseHeaderTimeout: cfg.Download.ResponseHeaderTimeout,
+		}),
+		downloader.WithHostConcurrency(cfg.Download.HostConcurrency),
+		downloader.WithHeaderPolicy(headerPolicy(cfg.Download)),
+		downloader.WithRedactedHeaders(cfg.Download.RedactHeaders...),
+	)
+}
+
+// headerPolicy converts "Header1|Header2" lists per host from the config.
+func headerPolicy(cfg config.DownloadConf) downloader.HeaderPolicy {
+	byHost := make(map[string][]string, len(cfg.ForwardHeadersByHost))
+	for host, names := range cfg.ForwardHeadersByHost {
+		byHost[strings.ToLower(host)] = strings.Split(names, "|")
+	}
+
+	return downloader.HeaderPolicy{
+		Allow:  cfg.ForwardHeaders,
+		ByHost: byHost,
+	}
+}
+
+// cachePolicy partitions the cache only by vary headers that are actually forwarded to some origin.
+func cachePolicy(cfg config.Config) service.CachePolicy {
+	forwarded := make(map[string]bool)
+	for _, name := range cfg.Download.ForwardHeaders {
+		forwarded[http.CanonicalHeaderKey(name)] = true
+	}
+	for _, names := range headerPolicy(cfg.Download).ByHost {
+		for _, name := range names {
+			forwarded[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+
+	var vary []string
+	for _, name := range cfg.Cache.VaryHeaders {
+		if forwarded[http.CanonicalHeaderKey(name)] {
+			vary = append(vary, name)
+		}
+	}
+
+	return service.CachePolicy{
+		VaryHeaders:       vary,
+		BypassCredentials: cfg.Cache.CredentialsPolicy == "bypass",
+	}
+}
+
+type rateLimits struct {
+	key    ratelimit.KeyFunc
+	routes map[string]*ratelimit.Limiter
+	miss   *ratelimit.Limiter
+}
+
+// newRateLimits creates limiters for every route and the cache miss limiter, routes without
+// a configured limit are not limited until a reload sets one. Clients are limited by ip
+// when api keys are disabled.
+func newRateLimits(cfg config.RateLimitConf, accounting *apikey.Accounting) (rateLimits, error) {
+	trusted, err := ratelimit.ParsePrefixes(cfg.TrustedProxies)
+	if err != nil {
+		return rateLimits{}, err
+	}
+
+	limits := rateLimits{
+		key:    ratelimit.ClientIP(trusted),
+		routes: make(map[string]*ratelimit.Limiter, len(server.RateLimitedRoutes)),
+		miss:   ratelimit.New(ratelimit.Limit{}),
+	}
+	switch cfg.KeyBy {
+	case "ip":
+	case "api_key":
+		if accounting != nil {
+			limits.key = ratelimit.APIKey(accounting.Name, limits.key)
+		}
+	default:
+		return rateLimits{}, fmt.Errorf("unknown rate limit key: %s", cfg.KeyBy)
+	}
+	for _, route := range server.RateLimitedRoutes {
+		limits.routes[route] = ratelimit.New(ratelimit.Limit{})
+	}
+
+	return limits, limits.update(cfg)
+}
+
+// update applies limits from the config to running limiters, nothing is changed on error.
+func (l rateLimits) update(cfg config.RateLimitConf) error {
+	routes := make(map[string]ratelimit.Limit, len(cfg.Routes))
+	for route, value := range cfg.Routes {
+		if _, ok := l.routes[route]; !ok {
+			return fmt.Errorf("unknown route %s, known routes: %v", route, server.RateLimitedRoutes)
+		}
+		limit, err := ratelimit.ParseLimit(value)
+		if err != nil {
+			return fmt.Errorf("route %s: %w", route, err)
+		}
+		routes[route] = limit
+	}
+	miss, err := ratelimit.ParseLimit(cfg.Miss)
+	if err != nil {
+		return fmt.Errorf("cache miss: %w", err)
+	}
+
+	for route, limiter := range l.routes {
+		limiter.SetLimit(routes[route])
+	}
+	l.miss.SetLimit(miss)
+
+	return nil
+}
+
+func newAccounting(cfg config.APIKeyConf) (*apikey.Accounting, error) {
+	keys, err := apikey.ParseKeys(cfg.Keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return apikey.NewAccounting(keys, cfg.UsagePath)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/esavich/otus_project/internal/config"
+	"github.com/esavich/otus_project/internal/downloader"
+	"github.com/esavich/otus_project/internal/logger"
+	"github.com/esavich/otus_project/internal/server"
+	"github.com/esavich/otus_project/internal/service"
+)
+
+const dotEnvFile = ".env"
+
+type cachePolicySetter interface {
+	SetCachePolicy(policy service.CachePolicy)
+}
+
+// reloader applies reloadable settings to running components: log level, header allowlists,
+// cache credentials policy and rate limits. Other settings need a restart.
+type reloader struct {
+	args       []string
+	current    *config.Config
+	downloader *downloader.Downloader
+	policies   []cachePolicySetter
+	limits     rateLimits
+	server     *server.Server
+}
+
+func (r *reloader) reload() {
+	loaded, err := config.Load(r.args)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Config reload failed, keeping the current config: %s", err))
+		return
+	}
+
+	next := r.reloadable(loaded)
+	for _, env := range config.Changed(next, loaded) {
+		slog.Warn(fmt.Sprintf("Config reload: %s can't be changed at runtime, restart to apply it", env))
+	}
+	changed := config.Changed(r.current, next)
+	if len(changed) == 0 {
+		slog.Info("Config reloaded, nothing to apply")
+		return
+	}
+
+	// limits are the only part that can fail, apply them first so nothing changes on error
+	if r.limits.key != nil {
+		if err := r.limits.update(next.Limits); err != nil {
+			slog.Error(fmt.Sprintf("Config reload failed, keeping the current config: %s", err))
+			return
+		}
+	}
+	logger.SetLevel(next.App.LogLevel)
+	r.downloader.SetHeaderPolicy(headerPolicy(next.Download), next.Download.RedactHeaders...)
+	policy := cachePolicy(*next)
+	for _, p := range r.policies {
+		p.SetCachePolicy(policy)
+	}
+	r.server.UpdateConfig(next)
+	r.current = next
+
+	slog.Info("Config reloaded", slog.Any("changed", changed))
+}
+
+// reloadable returns the current config with the reloadable settings taken from loaded.
+func (r *reloader) reloadable(loaded *config.Config) *config.Config {
+	next := *r.current
+
+	next.App.LogLevel = loaded.App.LogLevel
+	next.Download.ForwardHeaders = loaded.Download.ForwardHeaders
+	next.Download.ForwardHeadersByHost = loaded.Download.ForwardHeadersByHost
+	next.Download.RedactHeaders = loaded.Download.RedactHeaders
+	next.Cache.VaryHeaders = loaded.Cache.VaryHeaders
+	next.Cache.CredentialsPolicy = loaded.Cache.CredentialsPolicy
+	// rate limiting can't be turned on at runtime, the middleware is installed at start
+	if r.limits.key != nil {
+		next.Limits.Routes = loaded.Limits.Routes
+		next.Limits.Miss = loaded.Limits.Miss
+	}
+
+	return &next
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image/jpeg"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+
+	"github.com/esavich/otus_project/internal/batch"
+	"github.com/esavich/otus_project/internal/resizer"
+)
+
+// commands are subcommands run instead of the server, e.g. "resizer resize ...".
+var commands = map[string]func(args []string) int{
+	"resize": runResize,
+	"cache":  runCache,
+	"warmup": runWarmup,
+}
+
+// runResize resizes local files with the same pipeline as the server
+// and prints a json summary, it fails when any input fails.
+// Unlike the server it also reads png inputs.
+func runResize(args []string) int {
+	fs := flag.NewFlagSet("resize", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: resizer resize [flags] file or glob...")
+		fs.PrintDefaults()
+	}
+	var (
+		opts    batch.Options
+		size    = fs.String("size", "", "output size, WIDTHxHEIGHT")
+		mode    = fs.String("mode", string(resizer.Fill), "fill crops to the exact size, fit keeps the aspect ratio")
+		summary = fs.String("summary", "-", "file for the json summary, - for stdout")
+	)
+	fs.StringVar(&opts.OutDir, "out", ".", "output directory")
+	fs.StringVar(&opts.Format, "format", "", "output format, jpeg or png, the one named by the input extension by default")
+	fs.IntVar(&opts.Quality, "quality", jpeg.DefaultQuality, "jpeg quality, 1-100")
+	fs.IntVar(&opts.Parallelism, "parallel", runtime.NumCPU(), "images processed at once")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	var err error
+	opts.Width, opts.Height, err = resizer.ParseSize(*size)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 2
+	}
+	opts.Mode = resizer.Mode(*mode)
+	inputs, err := batch.Expand(fs.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	result, err := batch.Run(ctx, resizer.NewResizer(), inputs, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	if err := writeJSON(*summary, result); err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing summary:", err)
+		return 1
+	}
+	if result.Failed > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// writeJSON writes v to the file, - means stdout.
+func writeJSON(path string, v any) error {
+	out := os.Stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(v)
+}
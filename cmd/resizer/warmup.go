@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/esavich/otus_project/internal/diskcache"
+	"github.com/esavich/otus_project/internal/resizer"
+	"github.com/esavich/otus_project/internal/service"
+	"github.com/esavich/otus_project/internal/warmup"
+	"github.com/esavich/otus_project/internal/workerpool"
+)
+
+// runWarmup fills the persistent cache of a stopped server with the same services the server uses,
+// it fails when any image fails.
+func runWarmup(args []string) int {
+	fs := flag.NewFlagSet("warmup", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: resizer warmup [flags]")
+		fmt.Fprintln(fs.Output(), "Warms the cache dir of a stopped server, use POST /admin/cache/warmup on a running one.")
+		fs.PrintDefaults()
+	}
+	var (
+		list        = fs.String("list", "", "json request or a text file with one url per line")
+		sizes       = fs.String("sizes", "", "comma separated WIDTHxHEIGHT sizes, replace sizes of a json request")
+		concurrency = fs.Int("concurrency", 0, "images resized at once, CACHE_WARMUP_CONCURRENCY by default")
+		configFile  = fs.String("config", "", "config file of the server")
+		summary     = fs.String("summary", "-", "file for the json report, - for stdout")
+	)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if *list == "" {
+		fs.Usage()
+		return 2
+	}
+
+	req, err := warmup.ReadFile(*list)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading list:", err)
+		return 1
+	}
+	if *sizes != "" {
+		req.Sizes = strings.Split(*sizes, ",")
+	}
+	items, err := req.Items()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 2
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading config:", err)
+		return 1
+	}
+	if !cfg.Cache.Persistent {
+		fmt.Fprintln(os.Stderr, "Error: the server clears the cache on start without CACHE_PERSISTENT=true")
+		return 1
+	}
+	if len(items) > cfg.Cache.MaxItems {
+		fmt.Fprintf(os.Stderr, "Warning: %d images don't fit into CACHE_ITEMS=%d, the oldest are evicted\n",
+			len(items), cfg.Cache.MaxItems)
+	}
+	if *concurrency <= 0 {
+		*concurrency = cfg.Cache.WarmupConcurrency
+	}
+
+	// per image logs of the services go to stderr, only problems are interesting here
+	slog.SetLogLoggerLevel(slog.LevelWarn)
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	dc, err := diskcache.NewDiskCacheWrapper(cfg.Cache.MaxItems, cfg.Cache.Path, cacheOptions(cfg)...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error opening cache:", err)
+		return 1
+	}
+	pool := workerpool.New(cfg.Resize.Workers, max(cfg.Resize.QueueSize, *concurrency))
+	defer pool.Close()
+	getter := service.NewCachedImageService(
+		service.NewSimpleImageService(newDownloader(cfg), resizer.NewResizer(), pool),
+		dc,
+		service.WithCachePolicy(cachePolicy(*cfg)),
+	)
+
+	report := warmup.Run(ctx, getter, items, *concurrency)
+	if err := writeJSON(*summary, report); err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing report:", err)
+		return 1
+	}
+	if report.Failed > 0 {
+		return 1
+	}
+
+	return 0
+}
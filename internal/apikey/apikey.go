@@ -0,0 +1,77 @@
+package apikey
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Header     = "X-API-Key"
+	QueryParam = "api_key"
+)
+
+var ErrUnknownKey = errors.New("unknown api key")
+
+// QuotaError is returned when the daily quota of the key is used up.
+type QuotaError struct {
+	Name       string
+	RetryAfter time.Duration
+}
+
+func (e *QuotaError) Error() string {
+	return "daily quota exceeded for api key " + e.Name
+}
+
+// RetryAfterHeader returns the Retry-After value in whole seconds, at least one.
+func (e *QuotaError) RetryAfterHeader() string {
+	return strconv.Itoa(max(1, int(math.Ceil(e.RetryAfter.Seconds()))))
+}
+
+// Key is a client of the service, zero quotas mean unlimited.
+type Key struct {
+	Name           string
+	RequestsPerDay int64
+	BytesPerDay    int64
+}
+
+// ParseKeys parses keys from the "secret:name/requests/bytes" config format.
+func ParseKeys(values map[string]string) (map[string]Key, error) {
+	keys := make(map[string]Key, len(values))
+	names := make(map[string]bool, len(values))
+	for secret, value := range values {
+		parts := strings.Split(value, "/")
+		if secret == "" || parts[0] == "" || len(parts) > 3 {
+			return nil, fmt.Errorf("invalid api key definition %q", parts[0])
+		}
+		if names[parts[0]] {
+			return nil, fmt.Errorf("duplicate api key name %q", parts[0])
+		}
+		names[parts[0]] = true
+
+		key := Key{Name: parts[0]}
+		quotas := []*int64{&key.RequestsPerDay, &key.BytesPerDay}
+		for i, part := range parts[1:] {
+			quota, err := strconv.ParseInt(part, 10, 64)
+			if err != nil || quota < 0 {
+				return nil, fmt.Errorf("invalid quota %q for api key %q", part, key.Name)
+			}
+			*quotas[i] = quota
+		}
+		keys[secret] = key
+	}
+
+	return keys, nil
+}
+
+// FromRequest returns the api key from the header or the query param.
+func FromRequest(r *http.Request) string {
+	if key := r.Header.Get(Header); key != "" {
+		return key
+	}
+	return r.URL.Query().Get(QueryParam)
+}
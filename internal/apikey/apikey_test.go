@@ -0,0 +1,128 @@
+package apikey
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys(map[string]string{
+		"secret-a": "team-a/100/1000",
+		"secret-b": "team-b",
+		"secret-c": "team-c/5",
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]Key{
+		"secret-a": {Name: "team-a", RequestsPerDay: 100, BytesPerDay: 1000},
+		"secret-b": {Name: "team-b"},
+		"secret-c": {Name: "team-c", RequestsPerDay: 5},
+	}, keys)
+
+	for _, values := range []map[string]string{
+		{"secret": ""},
+		{"secret": "team/x"},
+		{"secret": "team/1/-1"},
+		{"secret": "team/1/1/1"},
+		{"a": "team", "b": "team"},
+	} {
+		_, err := ParseKeys(values)
+		require.Error(t, err, values)
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/fill/1/1/a.jpg?api_key=query", nil)
+	require.Equal(t, "query", FromRequest(r))
+
+	r.Header.Set(Header, "header")
+	require.Equal(t, "header", FromRequest(r))
+}
+
+func newTestAccounting(t *testing.T, now *time.Time) *Accounting {
+	t.Helper()
+
+	acc, err := NewAccounting(map[string]Key{
+		"secret": {Name: "team", RequestsPerDay: 2, BytesPerDay: 100},
+	}, filepath.Join(t.TempDir(), "usage.json"))
+	require.NoError(t, err)
+	acc.now = func() time.Time { return *now }
+
+	return acc
+}
+
+func TestAccounting_Name(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	acc := newTestAccounting(t, &now)
+
+	r := httptest.NewRequest(http.MethodGet, "/fill/1/1/a.jpg?api_key=secret", nil)
+	name, ok := acc.Name(r)
+	require.True(t, ok)
+	require.Equal(t, "team", name)
+
+	r.Header.Set(Header, "unknown")
+	_, ok = acc.Name(r)
+	require.False(t, ok)
+
+	// resolving the name is not a request of the key
+	require.Zero(t, acc.Usage()["team"].Requests)
+}
+
+func TestAccounting_Quotas(t *testing.T) {
+	now := time.Date(2024, 5, 1, 23, 0, 0, 0, time.UTC)
+	acc := newTestAccounting(t, &now)
+
+	_, err := acc.Begin("unknown")
+	require.ErrorIs(t, err, ErrUnknownKey)
+
+	key, err := acc.Begin("secret")
+	require.NoError(t, err)
+	require.Equal(t, "team", key.Name)
+	_, err = acc.Begin("secret")
+	require.NoError(t, err)
+
+	_, err = acc.Begin("secret")
+	var quotaErr *QuotaError
+	require.ErrorAs(t, err, &quotaErr)
+	require.Equal(t, time.Hour, quotaErr.RetryAfter)
+
+	// counters start over the next day
+	now = now.Add(2 * time.Hour)
+	_, err = acc.Begin("secret")
+	require.NoError(t, err)
+
+	acc.AddBytes("team", 100)
+	_, err = acc.Begin("secret")
+	require.ErrorAs(t, err, &quotaErr)
+
+	require.Equal(t, map[string]KeyUsage{
+		"team": {
+			Usage:          Usage{Date: "2024-05-02", Requests: 1, Bytes: 100},
+			RequestsPerDay: 2,
+			BytesPerDay:    100,
+		},
+	}, acc.Usage())
+}
+
+func TestAccounting_Persistence(t *testing.T) {
+	now := time.Now()
+	acc := newTestAccounting(t, &now)
+
+	_, err := acc.Begin("secret")
+	require.NoError(t, err)
+	acc.AddBytes("team", 42)
+	require.NoError(t, acc.Save())
+
+	restored, err := NewAccounting(acc.keys, acc.path)
+	require.NoError(t, err)
+	restored.now = acc.now
+	require.Equal(t, acc.Usage(), restored.Usage())
+
+	matches, err := filepath.Glob(filepath.Join(filepath.Dir(acc.path), "*.tmp"))
+	require.NoError(t, err)
+	require.Empty(t, matches)
+}
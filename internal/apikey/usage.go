@@ -0,0 +1,208 @@
+package apikey
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// Usage is the usage of a key during one UTC day.
+type Usage struct {
+	Date     string `json:"date"`
+	Requests int64  `json:"requests"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// KeyUsage is the usage report of a key.
+type KeyUsage struct {
+	Usage
+	RequestsPerDay int64 `json:"requestsPerDay"`
+	BytesPerDay    int64 `json:"bytesPerDay"`
+}
+
+// Accounting checks quotas and counts usage per key name. Usage is persisted to a json file,
+// so counters survive restarts.
+type Accounting struct {
+	mutex sync.Mutex
+	keys  map[string]Key
+	usage map[string]*Usage
+	path  string
+	dirty bool
+	now   func() time.Time
+}
+
+// NewAccounting loads the saved usage from path, a missing file means no usage yet.
+func NewAccounting(keys map[string]Key, path string) (*Accounting, error) {
+	a := &Accounting{
+		keys:  keys,
+		usage: make(map[string]*Usage),
+		path:  path,
+		now:   time.Now,
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cant read usage file: %w", err)
+	}
+	if err := json.Unmarshal(data, &a.usage); err != nil {
+		return nil, fmt.Errorf("cant parse usage file: %w", err)
+	}
+
+	return a, nil
+}
+
+// Begin authenticates the secret and counts a request. It fails when any daily quota is used up.
+func (a *Accounting) Begin(secret string) (Key, error) {
+	key, ok := a.keys[secret]
+	if !ok {
+		return Key{}, ErrUnknownKey
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	now := a.now().UTC()
+	u := a.today(key.Name, now)
+	if (key.RequestsPerDay > 0 && u.Requests >= key.RequestsPerDay) ||
+		(key.BytesPerDay > 0 && u.Bytes >= key.BytesPerDay) {
+		tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		return key, &QuotaError{Name: key.Name, RetryAfter: tomorrow.Sub(now)}
+	}
+	u.Requests++
+	a.dirty = true
+
+	return key, nil
+}
+
+// Name returns the name of the known key of the request, like Begin but without counting the request.
+func (a *Accounting) Name(r *http.Request) (string, bool) {
+	key, ok := a.keys[FromRequest(r)]
+	return key.Name, ok
+}
+
+// AddBytes counts bytes served to the key.
+func (a *Accounting) AddBytes(name string, n int64) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.today(name, a.now().UTC()).Bytes += n
+	a.dirty = true
+}
+
+// today returns the usage of the current day, counters are reset when the day changes.
+func (a *Accounting) today(name string, now time.Time) *Usage {
+	date := now.Format(dateLayout)
+	u, ok := a.usage[name]
+	if !ok || u.Date != date {
+		u = &Usage{Date: date}
+		a.usage[name] = u
+	}
+
+	return u
+}
+
+// Usage returns today's usage and quotas of every key by name.
+func (a *Accounting) Usage() map[string]KeyUsage {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	now := a.now().UTC()
+	report := make(map[string]KeyUsage, len(a.keys))
+	for _, key := range a.keys {
+		report[key.Name] = a.report(key, now)
+	}
+
+	return report
+}
+
+// KeyUsage returns the name and today's usage of the key with the secret. Unlike Begin it doesn't count a request.
+func (a *Accounting) KeyUsage(secret string) (string, KeyUsage, error) {
+	key, ok := a.keys[secret]
+	if !ok {
+		return "", KeyUsage{}, ErrUnknownKey
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return key.Name, a.report(key, a.now().UTC()), nil
+}
+
+func (a *Accounting) report(key Key, now time.Time) KeyUsage {
+	return KeyUsage{Usage: *a.today(key.Name, now), RequestsPerDay: key.RequestsPerDay, BytesPerDay: key.BytesPerDay}
+}
+
+// Save writes the usage file if something changed. The file is replaced atomically.
+func (a *Accounting) Save() error {
+	a.mutex.Lock()
+	if !a.dirty {
+		a.mutex.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(a.usage)
+	a.dirty = false
+	a.mutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("cant encode usage: %w", err)
+	}
+
+	if err := a.write(data); err != nil {
+		// try again next time
+		a.mutex.Lock()
+		a.dirty = true
+		a.mutex.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+func (a *Accounting) write(data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(a.path), ".usage-*.tmp")
+	if err != nil {
+		return fmt.Errorf("cant create usage file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cant write usage file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cant write usage file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), a.path); err != nil {
+		return fmt.Errorf("cant replace usage file: %w", err)
+	}
+
+	return nil
+}
+
+// SaveEvery saves the usage periodically until ctx is done.
+func (a *Accounting) SaveEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.Save(); err != nil {
+				slog.Error(fmt.Sprintf("Error saving api key usage: %s", err))
+			}
+		}
+	}
+}
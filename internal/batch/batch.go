@@ -0,0 +1,278 @@
+package batch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/esavich/otus_project/internal/resizer"
+)
+
+// Formats supported for inputs and outputs, an empty format keeps the format named by the input extension.
+const (
+	JPEG = "jpeg"
+	PNG  = "png"
+)
+
+type Resizer interface {
+	Resize(ctx context.Context, img image.Image, w int, h int, mode resizer.Mode) image.Image
+}
+
+type Options struct {
+	OutDir string
+	Width  int
+	Height int
+	Mode   resizer.Mode
+	// jpeg or png, empty keeps the input format
+	Format string
+	// jpeg quality 1-100
+	Quality int
+	// number of images processed at once, defaults to the number of CPUs
+	Parallelism int
+}
+
+// Result describes one input, Error is set when it failed.
+type Result struct {
+	Input    string `json:"input"`
+	Output   string `json:"output,omitempty"`
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+	Bytes    int64  `json:"bytes,omitempty"`
+	Duration string `json:"duration,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type Summary struct {
+	Total     int      `json:"total"`
+	Succeeded int      `json:"succeeded"`
+	Failed    int      `json:"failed"`
+	Duration  string   `json:"duration"`
+	Results   []Result `json:"results"`
+}
+
+func (o Options) validate() error {
+	var errs []error
+	if o.Width <= 0 || o.Height <= 0 {
+		errs = append(errs, fmt.Errorf("invalid size: %dx%d", o.Width, o.Height))
+	}
+	if _, err := resizer.ParseMode(string(o.Mode)); err != nil {
+		errs = append(errs, err)
+	}
+	switch o.Format {
+	case "", JPEG, PNG:
+	default:
+		errs = append(errs, fmt.Errorf("unknown format: %s", o.Format))
+	}
+	if o.Quality < 1 || o.Quality > 100 {
+		errs = append(errs, fmt.Errorf("quality must be between 1 and 100: %d", o.Quality))
+	}
+	if o.OutDir == "" {
+		errs = append(errs, errors.New("output directory is required"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Expand resolves glob patterns to files, plain paths are kept as is.
+// A pattern without matches is an error, duplicates are removed.
+func Expand(patterns []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %s", pattern)
+		}
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				files = append(files, match)
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// Run resizes inputs into opts.OutDir in parallel. Failed inputs are reported in the summary,
+// the error is returned only for invalid options or when the output directory can't be created.
+func Run(ctx context.Context, rz Resizer, inputs []string, opts Options) (Summary, error) {
+	if err := opts.validate(); err != nil {
+		return Summary{}, err
+	}
+	if err := os.MkdirAll(opts.OutDir, 0o755); err != nil {
+		return Summary{}, fmt.Errorf("cant create output directory: %w", err)
+	}
+	workers := opts.Parallelism
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	start := time.Now()
+	results := make([]Result, len(inputs))
+	outputs := make(map[string]string, len(inputs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for range workers {
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = process(ctx, rz, inputs[i], results[i].Output, opts)
+			}
+		}()
+	}
+	for i, input := range inputs {
+		results[i].Input = input
+		results[i].Output = filepath.Join(opts.OutDir, outputName(input, opts))
+		// inputs with the same name from different directories would overwrite each other
+		if other, ok := outputs[results[i].Output]; ok {
+			results[i].Error = fmt.Sprintf("output %s is already written for %s", results[i].Output, other)
+			continue
+		}
+		outputs[results[i].Output] = input
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	summary := Summary{
+		Total:    len(inputs),
+		Duration: time.Since(start).String(),
+		Results:  results,
+	}
+	for i := range results {
+		if results[i].Error != "" {
+			results[i].Output = ""
+			summary.Failed++
+		} else {
+			summary.Succeeded++
+		}
+	}
+
+	return summary, nil
+}
+
+func process(ctx context.Context, rz Resizer, input, output string, opts Options) Result {
+	start := time.Now()
+	result := Result{Input: input, Output: output}
+	if err := ctx.Err(); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	img, err := decode(input)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	resized := rz.Resize(ctx, img, opts.Width, opts.Height, opts.Mode)
+	size, err := writeImage(output, resized, outputFormat(input, opts), opts.Quality)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Width = resized.Bounds().Dx()
+	result.Height = resized.Bounds().Dy()
+	result.Bytes = size
+	result.Duration = time.Since(start).String()
+
+	return result
+}
+
+// decode accepts png as well as jpeg. The server serves only jpeg from origins,
+// png inputs are an extension of the command for images that come from design tools.
+func decode(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cant open input: %w", err)
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("cant decode input: %w", err)
+	}
+
+	return img, nil
+}
+
+// writeImage writes to a temporary file first, so failed runs don't leave partial outputs.
+func writeImage(path string, img image.Image, format string, quality int) (int64, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return 0, fmt.Errorf("cant create output: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	err = encode(tmp, img, format, quality)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("cant write output: %w", err)
+	}
+	info, err := os.Stat(tmp.Name())
+	if err != nil {
+		return 0, fmt.Errorf("cant write output: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, fmt.Errorf("cant write output: %w", err)
+	}
+
+	return info.Size(), nil
+}
+
+func encode(w io.Writer, img image.Image, format string, quality int) error {
+	if format == PNG {
+		return png.Encode(w, img)
+	}
+
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+}
+
+// outputFormat is opts.Format, or the format named by the input extension when it is empty.
+// The extension is used rather than the decoded format, so the output extension always matches its content.
+func outputFormat(input string, opts Options) string {
+	if opts.Format != "" {
+		return opts.Format
+	}
+	if strings.EqualFold(filepath.Ext(input), ".png") {
+		return PNG
+	}
+
+	return JPEG
+}
+
+// outputName is the input name with the size appended and the extension of the output format,
+// e.g. photo.png resized to 100x50 as jpeg is photo_100x50.jpg. An input extension naming
+// the output format is kept, so photo.jpeg stays photo_100x50.jpeg.
+func outputName(input string, opts Options) string {
+	base := filepath.Base(input)
+	ext := filepath.Ext(base)
+	name := strings.TrimSuffix(base, ext)
+
+	switch format := outputFormat(input, opts); {
+	case format == PNG && !strings.EqualFold(ext, ".png"):
+		ext = ".png"
+	case format == JPEG && !strings.EqualFold(ext, ".jpg") && !strings.EqualFold(ext, ".jpeg"):
+		ext = ".jpg"
+	}
+
+	return fmt.Sprintf("%s_%dx%d%s", name, opts.Width, opts.Height, ext)
+}
@@ -0,0 +1,181 @@
+package batch
+
+import (
+	"context"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/esavich/otus_project/internal/resizer"
+)
+
+func writeJPEG(t *testing.T, path string, w, h int) {
+	t.Helper()
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, jpeg.Encode(f, image.NewRGBA(image.Rect(0, 0, w, h)), nil))
+}
+
+func testOptions(dir string) Options {
+	return Options{
+		OutDir:      filepath.Join(dir, "out"),
+		Width:       50,
+		Height:      50,
+		Mode:        resizer.Fill,
+		Quality:     80,
+		Parallelism: 2,
+	}
+}
+
+func TestExpand(t *testing.T) {
+	dir := t.TempDir()
+	writeJPEG(t, filepath.Join(dir, "a.jpg"), 10, 10)
+	writeJPEG(t, filepath.Join(dir, "b.jpg"), 10, 10)
+
+	files, err := Expand([]string{filepath.Join(dir, "*.jpg"), filepath.Join(dir, "a.jpg")})
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(dir, "a.jpg"), filepath.Join(dir, "b.jpg")}, files)
+
+	_, err = Expand([]string{filepath.Join(dir, "*.png")})
+	require.Error(t, err)
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	writeJPEG(t, filepath.Join(dir, "wide.jpg"), 200, 100)
+	writeJPEG(t, filepath.Join(dir, "other", "wide.jpg"), 200, 100)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bad.jpg"), []byte("not an image"), 0o600))
+
+	opts := testOptions(dir)
+	inputs := []string{
+		filepath.Join(dir, "wide.jpg"),
+		filepath.Join(dir, "bad.jpg"),
+		filepath.Join(dir, "other", "wide.jpg"),
+	}
+	summary, err := Run(context.Background(), resizer.NewResizer(), inputs, opts)
+	require.NoError(t, err)
+	require.Equal(t, 3, summary.Total)
+	require.Equal(t, 1, summary.Succeeded)
+	require.Equal(t, 2, summary.Failed)
+
+	ok := summary.Results[0]
+	require.Empty(t, ok.Error)
+	require.Equal(t, filepath.Join(opts.OutDir, "wide_50x50.jpg"), ok.Output)
+	require.Equal(t, 50, ok.Width)
+	require.Equal(t, 50, ok.Height)
+	info, err := os.Stat(ok.Output)
+	require.NoError(t, err)
+	require.Equal(t, info.Size(), ok.Bytes)
+
+	require.Contains(t, summary.Results[1].Error, "cant decode")
+	require.Contains(t, summary.Results[2].Error, "already written")
+	require.Empty(t, summary.Results[2].Output)
+
+	entries, err := os.ReadDir(opts.OutDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "no temporary files are left")
+}
+
+func TestRun_FitPNG(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "wide.jpg")
+	writeJPEG(t, input, 200, 100)
+
+	opts := testOptions(dir)
+	opts.Mode = resizer.Fit
+	opts.Format = PNG
+	summary, err := Run(context.Background(), resizer.NewResizer(), []string{input}, opts)
+	require.NoError(t, err)
+	require.Equal(t, 1, summary.Succeeded)
+
+	result := summary.Results[0]
+	require.Equal(t, filepath.Join(opts.OutDir, "wide_50x50.png"), result.Output)
+	f, err := os.Open(result.Output)
+	require.NoError(t, err)
+	defer f.Close()
+	img, err := png.Decode(f)
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 50, 25), img.Bounds())
+}
+
+func TestRun_PNGInput(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "logo.png")
+	f, err := os.Create(input)
+	require.NoError(t, err)
+	require.NoError(t, png.Encode(f, image.NewRGBA(image.Rect(0, 0, 200, 100))))
+	require.NoError(t, f.Close())
+	// jpeg content under a png name is written as png, the name decides the format
+	disguised := filepath.Join(dir, "photo.png")
+	writeJPEG(t, disguised, 200, 100)
+
+	opts := testOptions(dir)
+	summary, err := Run(context.Background(), resizer.NewResizer(), []string{input, disguised}, opts)
+	require.NoError(t, err)
+	require.Equal(t, 2, summary.Succeeded)
+
+	for _, result := range summary.Results {
+		require.Equal(t, ".png", filepath.Ext(result.Output))
+		out, err := os.Open(result.Output)
+		require.NoError(t, err)
+		_, format, err := image.Decode(out)
+		out.Close()
+		require.NoError(t, err)
+		require.Equal(t, PNG, format)
+	}
+}
+
+func TestOutputName(t *testing.T) {
+	tests := []struct {
+		input  string
+		format string
+		want   string
+	}{
+		{input: "a.jpg", want: "a_50x50.jpg"},
+		{input: "a.JPEG", want: "a_50x50.JPEG"},
+		{input: "a.png", want: "a_50x50.png"},
+		{input: "a", want: "a_50x50.jpg"},
+		{input: "a.png", format: JPEG, want: "a_50x50.jpg"},
+		{input: "a.jpeg", format: PNG, want: "a_50x50.png"},
+	}
+	for _, tt := range tests {
+		opts := testOptions("")
+		opts.Format = tt.format
+		require.Equal(t, tt.want, outputName(tt.input, opts), tt.input+" "+tt.format)
+	}
+}
+
+func TestRun_InvalidOptions(t *testing.T) {
+	opts := testOptions(t.TempDir())
+	opts.Width = 0
+	opts.Mode = "stretch"
+	opts.Format = "gif"
+	opts.Quality = 0
+
+	_, err := Run(context.Background(), resizer.NewResizer(), nil, opts)
+	require.ErrorContains(t, err, "invalid size")
+	require.ErrorContains(t, err, "unknown resize mode")
+	require.ErrorContains(t, err, "unknown format")
+	require.ErrorContains(t, err, "quality")
+}
+
+func TestRun_Canceled(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "a.jpg")
+	writeJPEG(t, input, 10, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	summary, err := Run(ctx, resizer.NewResizer(), []string{input}, testOptions(dir))
+	require.NoError(t, err)
+	require.Equal(t, 1, summary.Failed)
+	require.Contains(t, summary.Results[0].Error, context.Canceled.Error())
+}
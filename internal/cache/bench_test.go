@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"testing"
+)
+
+const (
+	benchCapacity = 4096
+	benchShards   = 16
+)
+
+// benchKeys are twice the capacity, so that about half of the gets miss and sets evict.
+func benchKeys() []string {
+	keys := make([]string, benchCapacity*2)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+
+	return keys
+}
+
+// benchCache runs a mix of gets and sets, one set per ratio operations, from parallel goroutines.
+func benchCache(b *testing.B, c Cache[string, int], ratio int) {
+	b.Helper()
+
+	keys := benchKeys()
+	for i, key := range keys[:benchCapacity] {
+		c.Set(key, i, nil)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		rnd := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())) //nolint:gosec
+		for i := 0; pb.Next(); i++ {
+			n := rnd.IntN(len(keys))
+			if i%ratio == 0 {
+				c.Set(keys[n], n, nil)
+			} else {
+				c.Get(keys[n])
+			}
+		}
+	})
+}
+
+func BenchmarkLRU_Get(b *testing.B) {
+	benchCache(b, NewLRU[string, int](benchCapacity), 100)
+}
+
+func BenchmarkSharded_Get(b *testing.B) {
+	benchCache(b, NewSharded[string, int](benchCapacity, benchShards), 100)
+}
+
+func BenchmarkLRU_Mixed(b *testing.B) {
+	benchCache(b, NewLRU[string, int](benchCapacity), 4)
+}
+
+func BenchmarkSharded_Mixed(b *testing.B) {
+	benchCache(b, NewSharded[string, int](benchCapacity, benchShards), 4)
+}
@@ -2,52 +2,66 @@ package cache
 
 import (
 	"sync"
+	"sync/atomic"
 )
 
 type Key string
 
-type Cache interface {
-	Set(key Key, value interface{}, callback func(value interface{})) bool
-	Get(key Key) (interface{}, bool)
+type Cache[K comparable, V any] interface {
+	Set(key K, value V, callback func(value V)) bool
+	Get(key K) (V, bool)
+	// Peek returns the value without marking it as recently used.
+	Peek(key K) (V, bool)
+	// Remove deletes the key without calling the eviction callback.
+	Remove(key K) bool
+	// Range calls fn for items from the most to the least recently used until fn returns false.
+	// fn must not call the cache.
+	Range(fn func(key K, value V) bool)
+	Len() int
 	Clear()
 }
 
-type cacheItem struct {
-	key   Key
-	value interface{}
+type cacheItem[K comparable, V any] struct {
+	key   K
+	value V
+	// used is the clock value of the last Set or Get, zero without a clock
+	used uint64
 }
 
-type lruCache struct {
+type lruCache[K comparable, V any] struct {
 	capacity int
 	queue    List
-	items    map[Key]*ListItem
+	items    map[K]*ListItem
 	mutex    sync.Mutex
+	// clock orders items of several caches by recency, nil for a standalone cache
+	clock *atomic.Uint64
 }
 
-func (l *lruCache) Set(key Key, value interface{}, callback func(value interface{})) bool {
+func (l *lruCache[K, V]) Set(key K, value V, callback func(value V)) bool {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 	item, isInCache := l.items[key]
 	if isInCache {
-		ci := item.Value.(cacheItem)
+		ci := item.Value.(*cacheItem[K, V])
 		ci.value = value
-		item.Value = ci
+		ci.used = l.tick()
 		l.queue.MoveToFront(item)
 
 		return true
 	}
 
-	ci := cacheItem{
+	ci := &cacheItem[K, V]{
 		key:   key,
 		value: value,
+		used:  l.tick(),
 	}
 	if l.queue.Len() == l.capacity {
 		lastItem := l.queue.Back()
 		if callback != nil {
-			callback(lastItem.Value.(cacheItem).value)
+			callback(lastItem.Value.(*cacheItem[K, V]).value)
 		}
 		l.queue.Remove(lastItem)
-		delete(l.items, lastItem.Value.(cacheItem).key)
+		delete(l.items, lastItem.Value.(*cacheItem[K, V]).key)
 	}
 	newItem := l.queue.PushFront(ci)
 	l.items[key] = newItem
@@ -55,33 +69,111 @@ func (l *lruCache) Set(key Key, value interface{}, callback func(value interface
 	return false
 }
 
-func (l *lruCache) Get(key Key) (interface{}, bool) {
+func (l *lruCache[K, V]) Get(key K) (V, bool) {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
 	item, isInCache := l.items[key]
 	if isInCache {
 		l.queue.MoveToFront(item)
-		ci := item.Value.(cacheItem)
+		ci := item.Value.(*cacheItem[K, V])
+		ci.used = l.tick()
 
 		return ci.value, true
 	}
-	return nil, false
+	var zero V
+	return zero, false
 }
 
-func (l *lruCache) Clear() {
+func (l *lruCache[K, V]) Peek(key K) (V, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	item, isInCache := l.items[key]
+	if !isInCache {
+		var zero V
+		return zero, false
+	}
+
+	return item.Value.(*cacheItem[K, V]).value, true
+}
+
+func (l *lruCache[K, V]) Remove(key K) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	item, isInCache := l.items[key]
+	if !isInCache {
+		return false
+	}
+	l.queue.Remove(item)
+	delete(l.items, key)
+
+	return true
+}
+
+func (l *lruCache[K, V]) Range(fn func(key K, value V) bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for item := l.queue.Front(); item != nil; item = item.Next {
+		ci := item.Value.(*cacheItem[K, V])
+		if !fn(ci.key, ci.value) {
+			return
+		}
+	}
+}
+
+// snapshot appends items from the most to the least recently used to dst.
+func (l *lruCache[K, V]) snapshot(dst []cacheItem[K, V]) []cacheItem[K, V] {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for item := l.queue.Front(); item != nil; item = item.Next {
+		dst = append(dst, *item.Value.(*cacheItem[K, V]))
+	}
+
+	return dst
+}
+
+func (l *lruCache[K, V]) Len() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.queue.Len()
+}
+
+func (l *lruCache[K, V]) Clear() {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
 	l.queue = NewList()
-	l.items = make(map[Key]*ListItem, l.capacity)
+	l.items = make(map[K]*ListItem, l.capacity)
+}
+
+func (l *lruCache[K, V]) tick() uint64 {
+	if l.clock == nil {
+		return 0
+	}
+
+	return l.clock.Add(1)
 }
 
-func NewCache(capacity int) Cache {
-	return &lruCache{
+func newLRU[K comparable, V any](capacity int, clock *atomic.Uint64) *lruCache[K, V] {
+	return &lruCache[K, V]{
 		capacity: capacity,
 		queue:    NewList(),
-		items:    make(map[Key]*ListItem, capacity),
+		items:    make(map[K]*ListItem, capacity),
 		mutex:    sync.Mutex{},
+		clock:    clock,
 	}
 }
+
+// NewLRU returns a cache with a single lock, see NewSharded for concurrent use.
+func NewLRU[K comparable, V any](capacity int) Cache[K, V] {
+	return newLRU[K, V](capacity, nil)
+}
+
+func NewCache(capacity int) Cache[Key, interface{}] {
+	return NewLRU[Key, interface{}](capacity)
+}
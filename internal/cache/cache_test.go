@@ -150,3 +150,51 @@ func TestCacheCallback(t *testing.T) {
 
 	require.True(t, callbackCalled, "Callback must be called")
 }
+
+func TestCacheRemoveAndRange(t *testing.T) {
+	c := NewCache(3)
+	c.Set("aaa", 1, nil)
+	c.Set("bbb", 2, nil)
+	c.Set("ccc", 3, nil)
+
+	// moves aaa to the front
+	c.Get("aaa")
+
+	var keys []Key
+	c.Range(func(key Key, _ interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	require.Equal(t, []Key{"aaa", "ccc", "bbb"}, keys)
+
+	require.True(t, c.Remove("aaa"))
+	require.False(t, c.Remove("aaa"))
+	require.Equal(t, 2, c.Len())
+
+	_, ok := c.Get("aaa")
+	require.False(t, ok)
+
+	keys = nil
+	c.Range(func(key Key, _ interface{}) bool {
+		keys = append(keys, key)
+		return false
+	})
+	require.Equal(t, []Key{"ccc"}, keys)
+}
+
+func TestCachePeek(t *testing.T) {
+	c := NewCache(2)
+	c.Set("aaa", 1, nil)
+	c.Set("bbb", 2, nil)
+
+	value, ok := c.Peek("aaa")
+	require.True(t, ok)
+	require.Equal(t, 1, value)
+	_, ok = c.Peek("ccc")
+	require.False(t, ok)
+
+	// peek doesn't protect aaa from eviction
+	c.Set("ccc", 3, nil)
+	_, ok = c.Get("aaa")
+	require.False(t, ok)
+}
@@ -93,8 +93,13 @@ func (l *list) MoveToFront(i *ListItem) {
 		return
 	}
 
+	// relink the same item, the cache keeps pointers to items
 	l.Remove(i)
-	l.PushFront(i.Value)
+	l.len++
+	i.Prev = nil
+	i.Next = l.frontItem
+	l.frontItem.Prev = i
+	l.frontItem = i
 }
 
 func NewList() List {
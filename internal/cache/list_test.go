@@ -40,7 +40,11 @@ func TestList(t *testing.T) {
 		require.Equal(t, 70, l.Back().Value)
 
 		l.MoveToFront(l.Front()) // [80, 60, 40, 10, 30, 50, 70]
-		l.MoveToFront(l.Back())  // [70, 80, 60, 40, 10, 30, 50]
+		back := l.Back()
+		l.MoveToFront(back) // [70, 80, 60, 40, 10, 30, 50]
+		require.Same(t, back, l.Front())
+		require.Nil(t, l.Front().Prev)
+		require.Equal(t, 7, l.Len())
 
 		elems := make([]int, 0, l.Len())
 		for i := l.Front(); i != nil; i = i.Next {
@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"cmp"
+	"hash/maphash"
+	"slices"
+	"sync/atomic"
+)
+
+// minShardCapacity keeps small caches on few shards, so that they evict close to the exact LRU order.
+const minShardCapacity = 64
+
+type shardedCache[K comparable, V any] struct {
+	seed   maphash.Seed
+	shards []*lruCache[K, V]
+	clock  atomic.Uint64
+}
+
+// NewSharded returns a cache split into up to shards LRU caches with their own locks, a key always goes to the same
+// shard. Each shard holds at least minShardCapacity items and their capacities add up to capacity.
+// Eviction happens within a shard, so the evicted item is the least recently used one of its shard, not of the cache.
+// Range still visits items from the most to the least recently used across all shards.
+func NewSharded[K comparable, V any](capacity, shards int) Cache[K, V] {
+	shards = max(1, min(shards, capacity/minShardCapacity))
+	c := &shardedCache[K, V]{
+		seed:   maphash.MakeSeed(),
+		shards: make([]*lruCache[K, V], shards),
+	}
+	for i := range c.shards {
+		shardCapacity := capacity / shards
+		if i < capacity%shards {
+			shardCapacity++
+		}
+		c.shards[i] = newLRU[K, V](shardCapacity, &c.clock)
+	}
+
+	return c
+}
+
+func (c *shardedCache[K, V]) shard(key K) *lruCache[K, V] {
+	if len(c.shards) == 1 {
+		return c.shards[0]
+	}
+
+	return c.shards[maphash.Comparable(c.seed, key)%uint64(len(c.shards))]
+}
+
+func (c *shardedCache[K, V]) Set(key K, value V, callback func(value V)) bool {
+	return c.shard(key).Set(key, value, callback)
+}
+
+func (c *shardedCache[K, V]) Get(key K) (V, bool) {
+	return c.shard(key).Get(key)
+}
+
+func (c *shardedCache[K, V]) Peek(key K) (V, bool) {
+	return c.shard(key).Peek(key)
+}
+
+func (c *shardedCache[K, V]) Remove(key K) bool {
+	return c.shard(key).Remove(key)
+}
+
+// Range visits a snapshot of the items, shards are locked one by one while it is taken.
+func (c *shardedCache[K, V]) Range(fn func(key K, value V) bool) {
+	items := make([]cacheItem[K, V], 0, c.Len())
+	for _, s := range c.shards {
+		items = s.snapshot(items)
+	}
+	slices.SortFunc(items, func(a, b cacheItem[K, V]) int {
+		return cmp.Compare(b.used, a.used)
+	})
+
+	for _, ci := range items {
+		if !fn(ci.key, ci.value) {
+			return
+		}
+	}
+}
+
+func (c *shardedCache[K, V]) Len() int {
+	n := 0
+	for _, s := range c.shards {
+		n += s.Len()
+	}
+
+	return n
+}
+
+func (c *shardedCache[K, V]) Clear() {
+	for _, s := range c.shards {
+		s.Clear()
+	}
+}
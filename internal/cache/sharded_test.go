@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSharded(t *testing.T) {
+	t.Run("small capacity is one exact lru", func(t *testing.T) {
+		c := NewSharded[string, int](3, 16)
+		require.Len(t, c.(*shardedCache[string, int]).shards, 1)
+
+		c.Set("aaa", 1, nil)
+		c.Set("bbb", 2, nil)
+		c.Set("ccc", 3, nil)
+		c.Get("aaa")
+
+		var evicted []int
+		c.Set("ddd", 4, func(value int) {
+			evicted = append(evicted, value)
+		})
+		require.Equal(t, []int{2}, evicted)
+
+		_, ok := c.Get("bbb")
+		require.False(t, ok)
+	})
+
+	t.Run("capacity is split over shards", func(t *testing.T) {
+		c := NewSharded[int, int](1000, 8)
+		shards := c.(*shardedCache[int, int]).shards
+		require.Len(t, shards, 8)
+
+		total := 0
+		for _, s := range shards {
+			total += s.capacity
+		}
+		require.Equal(t, 1000, total)
+
+		evictions := 0
+		for i := range 5000 {
+			c.Set(i, i, func(int) { evictions++ })
+		}
+		require.Equal(t, 1000, c.Len())
+		require.Equal(t, 4000, evictions)
+
+		// the newest items of every shard are kept
+		for i := 4900; i < 5000; i++ {
+			value, ok := c.Peek(i)
+			require.True(t, ok)
+			require.Equal(t, i, value)
+		}
+
+		c.Clear()
+		require.Zero(t, c.Len())
+	})
+
+	t.Run("range is ordered across shards", func(t *testing.T) {
+		c := NewSharded[int, string](256, 4)
+		for i := range 100 {
+			c.Set(i, strconv.Itoa(i), nil)
+		}
+		c.Get(10)
+		c.Peek(20)
+		require.True(t, c.Remove(30))
+		require.False(t, c.Remove(30))
+
+		var keys []int
+		c.Range(func(key int, value string) bool {
+			require.Equal(t, strconv.Itoa(key), value)
+			keys = append(keys, key)
+			return true
+		})
+		require.Len(t, keys, 99)
+		require.Equal(t, []int{10, 99, 98}, keys[:3])
+		require.Equal(t, 0, keys[len(keys)-1])
+
+		keys = nil
+		c.Range(func(key int, _ string) bool {
+			keys = append(keys, key)
+			return len(keys) < 2
+		})
+		require.Equal(t, []int{10, 99}, keys)
+	})
+}
+
+func TestShardedMultithreading(t *testing.T) {
+	c := NewSharded[int, int](512, 8)
+	wg := &sync.WaitGroup{}
+	for worker := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 10_000 {
+				key := (worker*7 + i) % 2048
+				switch i % 4 {
+				case 0:
+					c.Remove(key)
+				case 1:
+					c.Set(key, key, nil)
+				default:
+					if value, ok := c.Get(key); ok && value != key {
+						t.Errorf("key %d has value %d", key, value)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.LessOrEqual(t, c.Len(), 512)
+}
@@ -0,0 +1,71 @@
+package cachecontrol
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// Directives are the parts of the origin Cache-Control header that matter for a shared cache.
+type Directives struct {
+	Private bool
+	NoStore bool
+	NoCache bool
+}
+
+// Parse parses the Cache-Control header value.
+func Parse(value string) Directives {
+	var d Directives
+	for _, directive := range strings.Split(value, ",") {
+		name, _, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "private":
+			d.Private = true
+		case "no-store":
+			d.NoStore = true
+		case "no-cache":
+			// we can't revalidate, so it is the same as no-store for us
+			d.NoCache = true
+		}
+	}
+
+	return d
+}
+
+// Shareable reports whether the response may be stored in a shared cache.
+func (d Directives) Shareable() bool {
+	return !d.Private && !d.NoStore && !d.NoCache
+}
+
+type recorderKey struct{}
+
+// Recorder keeps directives of the origin response for the caller up the stack.
+type Recorder struct {
+	mutex      sync.Mutex
+	directives Directives
+}
+
+// WithRecorder returns a context where the origin response directives are recorded.
+func WithRecorder(ctx context.Context) (context.Context, *Recorder) {
+	rec := &Recorder{}
+	return context.WithValue(ctx, recorderKey{}, rec), rec
+}
+
+// Record stores the Cache-Control header value of the origin response, if someone listens.
+func Record(ctx context.Context, value string) {
+	rec, ok := ctx.Value(recorderKey{}).(*Recorder)
+	if !ok {
+		return
+	}
+
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	rec.directives = Parse(value)
+}
+
+func (r *Recorder) Directives() Directives {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.directives
+}
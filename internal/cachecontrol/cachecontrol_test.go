@@ -0,0 +1,41 @@
+package cachecontrol
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value     string
+		shareable bool
+	}{
+		{value: "", shareable: true},
+		{value: "public, max-age=3600", shareable: true},
+		{value: "private", shareable: false},
+		{value: "Private, max-age=60", shareable: false},
+		{value: "max-age=0, no-store", shareable: false},
+		{value: "no-cache", shareable: false},
+		{value: `private="Set-Cookie"`, shareable: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			require.Equal(t, tt.shareable, Parse(tt.value).Shareable())
+		})
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	// no recorder in context, must not panic
+	Record(context.Background(), "private")
+
+	ctx, rec := WithRecorder(context.Background())
+	require.True(t, rec.Directives().Shareable())
+
+	Record(ctx, "private")
+	require.True(t, rec.Directives().Private)
+	require.False(t, rec.Directives().Shareable())
+}
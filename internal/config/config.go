@@ -2,38 +2,167 @@ package config
 
 import (
 	"fmt"
+	"maps"
+	"slices"
 	"time"
-
-	"github.com/ilyakaznacheev/cleanenv"
 )
 
 type Config struct {
-	Cache CacheConf
-	HTTP  HTTPConf
-	App   AppConf
+	Cache    CacheConf     `yaml:"cache"`
+	HTTP     HTTPConf      `yaml:"http"`
+	App      AppConf       `yaml:"app"`
+	Download DownloadConf  `yaml:"download"`
+	Resize   ResizeConf    `yaml:"resize"`
+	Tracing  TracingConf   `yaml:"tracing"`
+	Health   HealthConf    `yaml:"health"`
+	Limits   RateLimitConf `yaml:"limits"`
+	APIKeys  APIKeyConf    `yaml:"apiKeys"`
+
+	// path of the loaded config file, empty without one
+	File string `yaml:"-"`
 }
 
 type AppConf struct {
-	ServiceName     string        `env:"SERVICE_NAME" env-default:"reziser"`
-	LogLevel        string        `env:"LOG_LEVEL" env-default:"info"`
-	DownloadTimeout time.Duration `env:"DOWNLOAD_TIMEOUT" env-default:"2s"`
+	ServiceName string `env:"SERVICE_NAME" env-default:"reziser" yaml:"serviceName"`
+	LogLevel    string `env:"LOG_LEVEL" env-default:"info" yaml:"logLevel"`
+	// covers all retries of a download and the delays between them
+	DownloadTimeout time.Duration `env:"DOWNLOAD_TIMEOUT" env-default:"2s" yaml:"downloadTimeout"`
+	// how often the config file and .env are checked for changes, zero means reload on SIGHUP only
+	ReloadInterval time.Duration `env:"CONFIG_RELOAD_INTERVAL" env-default:"10s" yaml:"reloadInterval"`
+}
+type DownloadConf struct {
+	Retries        int           `env:"DOWNLOAD_RETRIES" env-default:"2" yaml:"retries"`
+	RetryBaseDelay time.Duration `env:"DOWNLOAD_RETRY_BASE_DELAY" env-default:"100ms" yaml:"retryBaseDelay"`
+	RetryMaxDelay  time.Duration `env:"DOWNLOAD_RETRY_MAX_DELAY" env-default:"2s" yaml:"retryMaxDelay"`
+
+	// per host circuit breaker, disabled when threshold is zero
+	BreakerThreshold int           `env:"BREAKER_THRESHOLD" env-default:"5" yaml:"breakerThreshold"`
+	BreakerCooldown  time.Duration `env:"BREAKER_COOLDOWN" env-default:"30s" yaml:"breakerCooldown"`
+
+	// connection pool, zero means http defaults
+	MaxConnsPerHost       int           `env:"DOWNLOAD_MAX_CONNS_PER_HOST" env-default:"16" yaml:"maxConnsPerHost"`
+	MaxIdleConns          int           `env:"DOWNLOAD_MAX_IDLE_CONNS" env-default:"100" yaml:"maxIdleConns"`
+	MaxIdleConnsPerHost   int           `env:"DOWNLOAD_MAX_IDLE_CONNS_PER_HOST" env-default:"8" yaml:"maxIdleConnsPerHost"`
+	IdleConnTimeout       time.Duration `env:"DOWNLOAD_IDLE_CONN_TIMEOUT" env-default:"90s" yaml:"idleConnTimeout"`
+	KeepAlive             time.Duration `env:"DOWNLOAD_KEEP_ALIVE" env-default:"30s" yaml:"keepAlive"`
+	DialTimeout           time.Duration `env:"DOWNLOAD_DIAL_TIMEOUT" env-default:"1s" yaml:"dialTimeout"`
+	TLSHandshakeTimeout   time.Duration `env:"DOWNLOAD_TLS_TIMEOUT" env-default:"1s" yaml:"tlsHandshakeTimeout"`
+	ResponseHeaderTimeout time.Duration `env:"DOWNLOAD_RESPONSE_HEADER_TIMEOUT" env-default:"2s" yaml:"responseHeaderTimeout"` //nolint:lll
+
+	// concurrent requests per origin host, excess requests are queued, zero means no limit
+	HostConcurrency int `env:"DOWNLOAD_HOST_CONCURRENCY" env-default:"8" yaml:"hostConcurrency"`
+
+	// client headers forwarded to every origin
+	ForwardHeaders []string `env:"FORWARD_HEADERS" env-default:"Accept,Accept-Language,User-Agent,X-Request-ID" yaml:"forwardHeaders"` //nolint:lll
+	// extra headers per origin host name, e.g. "private.example.com:Authorization|Cookie"
+	ForwardHeadersByHost map[string]string `env:"FORWARD_HEADERS_BY_HOST" yaml:"forwardHeadersByHost"`
+	// headers hidden in logs in addition to Authorization, Cookie and other credentials
+	RedactHeaders []string `env:"LOG_REDACT_HEADERS" yaml:"redactHeaders"`
 }
+
+type ResizeConf struct {
+	// zero means the number of CPUs
+	Workers   int `env:"RESIZE_WORKERS" env-default:"0" yaml:"workers"`
+	QueueSize int `env:"RESIZE_QUEUE_SIZE" env-default:"64" yaml:"queueSize"`
+}
+
+type TracingConf struct {
+	// none, stdout or otlp
+	Exporter    string  `env:"TRACING_EXPORTER" env-default:"none" yaml:"exporter"`
+	Endpoint    string  `env:"TRACING_OTLP_ENDPOINT" env-default:"localhost:4318" yaml:"endpoint"`
+	Insecure    bool    `env:"TRACING_OTLP_INSECURE" env-default:"true" yaml:"insecure"`
+	SampleRatio float64 `env:"TRACING_SAMPLE_RATIO" env-default:"1" yaml:"sampleRatio"`
+}
+
 type CacheConf struct {
-	MaxItems int    `env:"CACHE_ITEMS" env-default:"10"`
-	Path     string `env:"CACHE_PATH" env-default:"./cache"`
+	// images kept in the cache. From 128 items the index is split into shards evicting on their own,
+	// so an evicted image is the least recently used of its shard, not always of the whole cache
+	MaxItems int    `env:"CACHE_ITEMS" env-default:"10" yaml:"maxItems"`
+	Path     string `env:"CACHE_PATH" env-default:"./cache" yaml:"path"`
+	// keep cached images between restarts, otherwise the cache dir is cleared on start and shutdown
+	Persistent bool `env:"CACHE_PERSISTENT" env-default:"false" yaml:"persistent"`
+	// levels of subdirectories named by hash prefixes, e.g. ab/cd/<hash>.jpg for 2, zero keeps files flat
+	FanOut int `env:"CACHE_FAN_OUT" env-default:"0" yaml:"fanOut"`
+	// archive from "resizer cache export" loaded on start when the cache is empty
+	SeedPath string `env:"CACHE_SEED_PATH" yaml:"seedPath"`
+	// images resized at once by the warm-up endpoint
+	WarmupConcurrency int `env:"CACHE_WARMUP_CONCURRENCY" env-default:"4" yaml:"warmupConcurrency"`
+
+	// negative cache of upstream failures, disabled when ttl is zero, its items are evicted by shard like MaxItems
+	NegativeTTL       time.Duration `env:"NEGATIVE_CACHE_TTL" env-default:"30s" yaml:"negativeTTL"`
+	NegativeMaxItems  int           `env:"NEGATIVE_CACHE_ITEMS" env-default:"1000" yaml:"negativeMaxItems"`
+	NegativeTransient bool          `env:"NEGATIVE_CACHE_TRANSIENT" env-default:"false" yaml:"negativeTransient"`
+
+	// requests forwarding these headers to origins get a separate cache partition,
+	// or skip the cache with the bypass policy
+	VaryHeaders       []string `env:"CACHE_VARY_HEADERS" env-default:"Authorization,Cookie" yaml:"varyHeaders"`
+	CredentialsPolicy string   `env:"CACHE_CREDENTIALS_POLICY" env-default:"partition" yaml:"credentialsPolicy"`
 }
 
 type HTTPConf struct {
-	Host string `env:"HOST" env-default:"0.0.0.0"`
-	Port int    `env:"PORT" env-default:"8081"`
+	Host string `env:"HOST" env-default:"0.0.0.0" yaml:"host"`
+	Port int    `env:"PORT" env-default:"8081" yaml:"port"`
+
+	// time to report not ready before closing connections on shutdown, part of the shutdown timeout
+	DrainDelay      time.Duration `env:"SHUTDOWN_DRAIN_DELAY" env-default:"0s" yaml:"drainDelay"`
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" env-default:"10s" yaml:"shutdownTimeout"`
+
+	// tls is enabled when both files are set, certificates are reloaded when the files change or on SIGHUP
+	TLSCertFile       string        `env:"TLS_CERT_FILE" yaml:"tlsCertFile"`
+	TLSKeyFile        string        `env:"TLS_KEY_FILE" yaml:"tlsKeyFile"`
+	TLSReloadInterval time.Duration `env:"TLS_RELOAD_INTERVAL" env-default:"30s" yaml:"tlsReloadInterval"`
+	// plain http listener redirecting to https, disabled when zero
+	RedirectPort int `env:"HTTP_REDIRECT_PORT" env-default:"0" yaml:"redirectPort"`
+
+	// separate listener for metrics, pprof and management routes, disabled when the port is zero
+	AdminHost string `env:"ADMIN_HOST" env-default:"127.0.0.1" yaml:"adminHost"`
+	AdminPort int    `env:"ADMIN_PORT" env-default:"0" yaml:"adminPort"`
+	// bearer token of the admin routes; without the admin listener the cache admin api is disabled when empty
+	AdminToken string `env:"ADMIN_TOKEN" yaml:"adminToken"`
+}
+
+type RateLimitConf struct {
+	Enabled bool `env:"RATE_LIMIT_ENABLED" env-default:"false" yaml:"enabled"`
+	// "requests per second/burst" by route name: fill, usage, metrics, healthz, readyz; others are not limited
+	Routes map[string]string `env:"RATE_LIMIT_ROUTES" env-default:"fill:20/40" yaml:"routes"`
+	// lower limit for requests that miss the cache and need a download and resize
+	Miss string `env:"RATE_LIMIT_MISS" env-default:"2/5" yaml:"miss"`
+	// ip or api_key, clients without a known api key are limited by ip
+	KeyBy string `env:"RATE_LIMIT_KEY" env-default:"ip" yaml:"keyBy"`
+	// proxies allowed to set X-Forwarded-For, addresses or networks
+	TrustedProxies []string `env:"TRUSTED_PROXIES" yaml:"trustedProxies"`
+}
+
+type APIKeyConf struct {
+	// "secret:name/requests per day/bytes per day", zero quota means unlimited,
+	// authentication is disabled when there are no keys
+	Keys               map[string]string `env:"API_KEYS" yaml:"keys"`
+	UsagePath          string            `env:"API_USAGE_PATH" env-default:"./usage.json" yaml:"usagePath"`
+	UsageFlushInterval time.Duration     `env:"API_USAGE_FLUSH_INTERVAL" env-default:"10s" yaml:"usageFlushInterval"`
 }
 
-func Load() (*Config, error) {
-	var cfg Config
-	err := cleanenv.ReadConfig(".env", &cfg)
-	if err != nil {
-		return nil, fmt.Errorf("error loading config: %w", err)
+func (c HTTPConf) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+type HealthConf struct {
+	// readiness fails when the cache filesystem has less free space
+	MinFreeBytes uint64 `env:"HEALTH_MIN_FREE_BYTES" env-default:"104857600" yaml:"minFreeBytes"`
+}
+
+// Redacted returns a copy of the config with secrets replaced, safe to show.
+func (c Config) Redacted() Config {
+	const redacted = "[REDACTED]"
+
+	if c.HTTP.AdminToken != "" {
+		c.HTTP.AdminToken = redacted
+	}
+
+	keys := make(map[string]string, len(c.APIKeys.Keys))
+	for i, value := range slices.Sorted(maps.Values(c.APIKeys.Keys)) {
+		keys[fmt.Sprintf("%s-%d", redacted, i+1)] = value
 	}
+	c.APIKeys.Keys = keys
 
-	return &cfg, nil
+	return c
 }
@@ -0,0 +1,187 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// setupDir runs the test in an empty directory, so no .env is picked up.
+func setupDir(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	return dir
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	setupDir(t)
+
+	cfg, err := Load(nil)
+	require.NoError(t, err)
+	require.Equal(t, 8081, cfg.HTTP.Port)
+	require.Equal(t, "./cache", cfg.Cache.Path)
+	require.Equal(t, 2*time.Second, cfg.App.DownloadTimeout)
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	dir := setupDir(t)
+
+	file := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(`
+http:
+  port: 9000
+  host: 127.0.0.1
+cache:
+  maxItems: 50
+app:
+  logLevel: debug
+`), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".env"), []byte("LOG_LEVEL=warn\nCACHE_ITEMS=20\n"), 0o600))
+	t.Setenv("CACHE_ITEMS", "30")
+	t.Setenv("PORT", "9001")
+
+	cfg, err := Load([]string{"-config", file, "-port", "9002"})
+	require.NoError(t, err)
+
+	// flag > env > file > defaults
+	require.Equal(t, 9002, cfg.HTTP.Port)
+	require.Equal(t, 30, cfg.Cache.MaxItems)
+	require.Equal(t, "127.0.0.1", cfg.HTTP.Host)
+	require.Equal(t, 10*time.Second, cfg.HTTP.ShutdownTimeout)
+	// .env fills the environment, so it overrides the file but not the real env vars
+	require.Equal(t, "warn", cfg.App.LogLevel)
+}
+
+func TestLoad_TOML(t *testing.T) {
+	dir := setupDir(t)
+
+	file := filepath.Join(dir, "config.toml")
+	require.NoError(t, os.WriteFile(file, []byte("[http]\nport = 9100\n\n[limits]\nenabled = true\n"), 0o600))
+	t.Setenv("CONFIG_FILE", file)
+
+	cfg, err := Load(nil)
+	require.NoError(t, err)
+	require.Equal(t, 9100, cfg.HTTP.Port)
+	require.True(t, cfg.Limits.Enabled)
+}
+
+func TestLoad_FileZeroValues(t *testing.T) {
+	dir := setupDir(t)
+
+	file := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(`
+download:
+  retries: 0
+  breakerThreshold: 0
+  hostConcurrency: 0
+cache:
+  negativeTTL: 0s
+limits:
+  routes:
+    metrics: 5/5
+`), 0o600))
+
+	cfg, err := Load([]string{"-config", file})
+	require.NoError(t, err)
+	// zero values in the file are kept instead of being replaced by defaults
+	require.Zero(t, cfg.Download.Retries)
+	require.Zero(t, cfg.Download.BreakerThreshold)
+	require.Zero(t, cfg.Download.HostConcurrency)
+	require.Zero(t, cfg.Cache.NegativeTTL)
+	// maps from the file replace the default instead of being merged into it
+	require.Equal(t, map[string]string{"metrics": "5/5"}, cfg.Limits.Routes)
+}
+
+func TestLoad_KeepsEnvironment(t *testing.T) {
+	dir := setupDir(t)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".env"), []byte("LOG_LEVEL=warn\n"), 0o600))
+	environ := os.Environ()
+
+	cfg, err := Load([]string{"-port", "9000"})
+	require.NoError(t, err)
+	require.Equal(t, 9000, cfg.HTTP.Port)
+	require.Equal(t, "warn", cfg.App.LogLevel)
+	// neither .env nor flags are set in the process environment
+	require.Equal(t, environ, os.Environ())
+}
+
+func TestLoad_BoolFlag(t *testing.T) {
+	setupDir(t)
+
+	cfg, err := Load([]string{"-rate-limit-enabled", "-cache-vary-headers", "Cookie"})
+	require.NoError(t, err)
+	require.True(t, cfg.Limits.Enabled)
+	require.Equal(t, []string{"Cookie"}, cfg.Cache.VaryHeaders)
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	setupDir(t)
+
+	_, err := Load([]string{"-unknown-flag", "1"})
+	require.Error(t, err)
+
+	_, err = Load([]string{"-config", "missing.yaml"})
+	require.Error(t, err)
+
+	_, err = Load([]string{"-port", "70000", "-download-timeout", "0s", "-log-level", "verbose"})
+	require.ErrorContains(t, err, "PORT must be in range")
+	require.ErrorContains(t, err, "DOWNLOAD_TIMEOUT must be positive")
+	require.ErrorContains(t, err, "LOG_LEVEL must be one of")
+}
+
+func TestValidate(t *testing.T) {
+	dir := setupDir(t)
+
+	cfg, err := Load(nil)
+	require.NoError(t, err)
+	require.NoError(t, cfg.Validate())
+
+	// a missing cache dir is fine if it can be created, validation doesn't create it
+	cfg.Cache.Path = filepath.Join(dir, "new", "cache")
+	require.NoError(t, cfg.Validate())
+	require.NoDirExists(t, filepath.Join(dir, "new"))
+
+	cfg.HTTP.TLSCertFile = "cert.pem"
+	cfg.HTTP.AdminPort = cfg.HTTP.Port
+	cfg.Tracing.SampleRatio = 2
+	cfg.HTTP.DrainDelay = cfg.HTTP.ShutdownTimeout
+	readonly := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(readonly, nil, 0o600))
+	cfg.Cache.Path = readonly
+
+	err = cfg.Validate()
+	require.ErrorContains(t, err, "TLS_CERT_FILE and TLS_KEY_FILE")
+	require.ErrorContains(t, err, "ADMIN_PORT must differ")
+	require.ErrorContains(t, err, "TRACING_SAMPLE_RATIO")
+	require.ErrorContains(t, err, "SHUTDOWN_DRAIN_DELAY must be less than SHUTDOWN_TIMEOUT")
+	require.ErrorContains(t, err, "CACHE_PATH is not writable")
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := Config{}
+	cfg.HTTP.AdminToken = "token"
+	cfg.APIKeys.Keys = map[string]string{"secret": "team/1/1"}
+
+	redacted := cfg.Redacted()
+	require.Equal(t, "[REDACTED]", redacted.HTTP.AdminToken)
+	require.Equal(t, map[string]string{"[REDACTED]-1": "team/1/1"}, redacted.APIKeys.Keys)
+	require.Equal(t, "token", cfg.HTTP.AdminToken)
+}
+
+func TestChanged(t *testing.T) {
+	setupDir(t)
+
+	old, err := Load(nil)
+	require.NoError(t, err)
+	updated, err := Load([]string{"-log-level", "debug", "-port", "9000", "-forward-headers", "Accept"})
+	require.NoError(t, err)
+
+	require.Empty(t, Changed(old, old))
+	require.ElementsMatch(t, []string{"LOG_LEVEL", "PORT", "FORWARD_HEADERS"}, Changed(old, updated))
+}
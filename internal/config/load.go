@@ -0,0 +1,310 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ilyakaznacheev/cleanenv"
+	"github.com/joho/godotenv"
+)
+
+const (
+	dotEnvFile = ".env"
+	// env var with the config file path, the -config flag takes precedence
+	fileEnv = "CONFIG_FILE"
+)
+
+// Load reads the config with precedence flags > env > file > defaults.
+//
+// The yaml or toml file is optional, its path is set by -config or CONFIG_FILE.
+// A .env file in the working directory is optional too, its variables don't override the environment.
+// Neither .env nor flags change the process environment.
+// Every env variable has a flag, e.g. CACHE_PATH is -cache-path.
+func Load(args []string) (*Config, error) {
+	overrides, file, err := parseFlags(args)
+	if err != nil {
+		return nil, err
+	}
+
+	vars, err := godotenv.Read(dotEnvFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("error loading %s: %w", dotEnvFile, err)
+	}
+	lookup := func(env string) (string, bool) {
+		if value, ok := overrides[env]; ok {
+			return value, true
+		}
+		if value, ok := os.LookupEnv(env); ok {
+			return value, true
+		}
+		value, ok := vars[env]
+		return value, ok
+	}
+
+	// defaults go first, so that zero values set in the file are kept
+	var cfg Config
+	err = setFields(reflect.ValueOf(&cfg).Elem(), func(field reflect.StructField) (string, bool) {
+		return field.Tag.Lookup("env-default")
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error loading config defaults: %w", err)
+	}
+
+	if file == "" {
+		file, _ = lookup(fileEnv)
+	}
+	if file != "" {
+		if err := readFile(file, &cfg); err != nil {
+			return nil, fmt.Errorf("error loading config: %w", err)
+		}
+	}
+
+	err = setFields(reflect.ValueOf(&cfg).Elem(), func(field reflect.StructField) (string, bool) {
+		return lookup(field.Tag.Get("env"))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error loading config: %w", err)
+	}
+	cfg.File = file
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config:\n%w", err)
+	}
+
+	return &cfg, nil
+}
+
+// readFile reads a yaml or toml file over cfg. Fields missing in the file keep their values,
+// maps set in the file replace the current ones instead of being merged into them.
+func readFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var parse func(r io.Reader, v any) error
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		parse = cleanenv.ParseYAML
+	case ".json":
+		parse = cleanenv.ParseJSON
+	case ".toml":
+		parse = cleanenv.ParseTOML
+	default:
+		return fmt.Errorf("unsupported config file format %q", ext)
+	}
+
+	var fromFile Config
+	if err := parse(bytes.NewReader(data), &fromFile); err != nil {
+		return fmt.Errorf("can't parse %s: %w", path, err)
+	}
+	if err := parse(bytes.NewReader(data), cfg); err != nil {
+		return fmt.Errorf("can't parse %s: %w", path, err)
+	}
+	replaceMaps(reflect.ValueOf(cfg).Elem(), reflect.ValueOf(fromFile))
+
+	return nil
+}
+
+// replaceMaps sets maps of dst to the non-nil maps of src.
+func replaceMaps(dst, src reflect.Value) {
+	for i := 0; i < dst.NumField(); i++ {
+		switch field := src.Field(i); field.Kind() {
+		case reflect.Map:
+			if !field.IsNil() {
+				dst.Field(i).Set(field)
+			}
+		case reflect.Struct:
+			replaceMaps(dst.Field(i), field)
+		default:
+		}
+	}
+}
+
+// setFields sets every field with an env tag to its value, fields without a value are kept.
+func setFields(v reflect.Value, value func(field reflect.StructField) (string, bool)) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		env, ok := field.Tag.Lookup("env")
+		if !ok {
+			if field.Type.Kind() == reflect.Struct {
+				if err := setFields(v.Field(i), value); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		raw, ok := value(field)
+		if !ok {
+			continue
+		}
+		if err := parseValue(v.Field(i), raw); err != nil {
+			return fmt.Errorf("invalid %s %q: %w", env, raw, err)
+		}
+	}
+
+	return nil
+}
+
+// parseValue parses raw into v the way cleanenv does: lists are comma separated,
+// map items are "key:value".
+func parseValue(v reflect.Value, raw string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		slice := reflect.MakeSlice(v.Type(), 0, 0)
+		if strings.TrimSpace(raw) != "" {
+			for _, item := range strings.Split(raw, ",") {
+				elem := reflect.New(v.Type().Elem()).Elem()
+				if err := parseValue(elem, item); err != nil {
+					return err
+				}
+				slice = reflect.Append(slice, elem)
+			}
+		}
+		v.Set(slice)
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		if strings.TrimSpace(raw) != "" {
+			for _, item := range strings.Split(raw, ",") {
+				key, value, ok := strings.Cut(item, ":")
+				if !ok {
+					return fmt.Errorf("invalid map item %q", item)
+				}
+				k := reflect.New(v.Type().Key()).Elem()
+				if err := parseValue(k, key); err != nil {
+					return err
+				}
+				e := reflect.New(v.Type().Elem()).Elem()
+				if err := parseValue(e, value); err != nil {
+					return err
+				}
+				m.SetMapIndex(k, e)
+			}
+		}
+		v.Set(m)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
+
+// parseFlags returns values of the set flags by env name and the config file path.
+func parseFlags(args []string) (map[string]string, string, error) {
+	fs := flag.NewFlagSet("resizer", flag.ContinueOnError)
+	file := fs.String("config", "", "path to a yaml or toml config file, overrides "+fileEnv)
+
+	overrides := make(map[string]string)
+	for _, field := range envFields(reflect.TypeOf(Config{})) {
+		env := field.Tag.Get("env")
+		name := strings.ReplaceAll(strings.ToLower(env), "_", "-")
+		usage := "overrides " + env
+		if def, ok := field.Tag.Lookup("env-default"); ok {
+			usage += fmt.Sprintf(" (default %q)", def)
+		}
+
+		set := func(value string) error {
+			overrides[env] = value
+			return nil
+		}
+		if field.Type.Kind() == reflect.Bool {
+			fs.BoolFunc(name, usage, set)
+			continue
+		}
+		fs.Func(name, usage, set)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, "", err
+	}
+	if fs.NArg() > 0 {
+		return nil, "", fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	return overrides, *file, nil
+}
+
+// envFields returns all fields with an env tag, including the ones of nested structs.
+func envFields(t reflect.Type) []reflect.StructField {
+	var fields []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if _, ok := field.Tag.Lookup("env"); ok {
+			fields = append(fields, field)
+			continue
+		}
+		if field.Type.Kind() == reflect.Struct {
+			fields = append(fields, envFields(field.Type)...)
+		}
+	}
+
+	return fields
+}
+
+// Changed returns env names of the settings that differ between two configs.
+func Changed(old, updated *Config) []string {
+	return changedFields(reflect.ValueOf(*old), reflect.ValueOf(*updated))
+}
+
+func changedFields(old, updated reflect.Value) []string {
+	var changed []string
+	for i := 0; i < old.NumField(); i++ {
+		field := old.Type().Field(i)
+		if env, ok := field.Tag.Lookup("env"); ok {
+			if !reflect.DeepEqual(old.Field(i).Interface(), updated.Field(i).Interface()) {
+				changed = append(changed, env)
+			}
+			continue
+		}
+		if field.Type.Kind() == reflect.Struct {
+			changed = append(changed, changedFields(old.Field(i), updated.Field(i))...)
+		}
+	}
+
+	return changed
+}
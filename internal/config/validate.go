@@ -0,0 +1,107 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// Validate checks the config semantically and reports all problems at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	oneOf := func(name, value string, allowed ...string) {
+		check(slices.Contains(allowed, value), "%s must be one of %v, got %q", name, allowed, value)
+	}
+	positive := func(name string, d time.Duration) {
+		check(d > 0, "%s must be positive, got %s", name, d)
+	}
+	notNegative := func(name string, d time.Duration) {
+		check(d >= 0, "%s must not be negative, got %s", name, d)
+	}
+	port := func(name string, p int, optional bool) {
+		check((optional && p == 0) || (p > 0 && p <= 65535), "%s must be in range 1-65535, got %d", name, p)
+	}
+
+	oneOf("LOG_LEVEL", c.App.LogLevel, "debug", "info", "warn", "error")
+	positive("DOWNLOAD_TIMEOUT", c.App.DownloadTimeout)
+	notNegative("CONFIG_RELOAD_INTERVAL", c.App.ReloadInterval)
+
+	port("PORT", c.HTTP.Port, false)
+	port("ADMIN_PORT", c.HTTP.AdminPort, true)
+	port("HTTP_REDIRECT_PORT", c.HTTP.RedirectPort, true)
+	check(c.HTTP.AdminPort == 0 || c.HTTP.AdminPort != c.HTTP.Port, "ADMIN_PORT must differ from PORT")
+	check(c.HTTP.RedirectPort == 0 || c.HTTP.RedirectPort != c.HTTP.Port, "HTTP_REDIRECT_PORT must differ from PORT")
+	check((c.HTTP.TLSCertFile == "") == (c.HTTP.TLSKeyFile == ""), "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	check(c.HTTP.RedirectPort == 0 || c.HTTP.TLSEnabled(), "HTTP_REDIRECT_PORT requires tls")
+	positive("SHUTDOWN_TIMEOUT", c.HTTP.ShutdownTimeout)
+	notNegative("SHUTDOWN_DRAIN_DELAY", c.HTTP.DrainDelay)
+	// the drain delay is spent from the shutdown timeout, in-flight requests need the rest
+	check(c.HTTP.DrainDelay < c.HTTP.ShutdownTimeout, "SHUTDOWN_DRAIN_DELAY must be less than SHUTDOWN_TIMEOUT")
+	notNegative("TLS_RELOAD_INTERVAL", c.HTTP.TLSReloadInterval)
+
+	check(c.Download.Retries >= 0, "DOWNLOAD_RETRIES must not be negative, got %d", c.Download.Retries)
+	notNegative("DOWNLOAD_RETRY_BASE_DELAY", c.Download.RetryBaseDelay)
+	check(c.Download.RetryMaxDelay >= c.Download.RetryBaseDelay,
+		"DOWNLOAD_RETRY_MAX_DELAY must not be less than DOWNLOAD_RETRY_BASE_DELAY")
+	check(c.Download.BreakerThreshold >= 0, "BREAKER_THRESHOLD must not be negative, got %d", c.Download.BreakerThreshold)
+	positive("DOWNLOAD_DIAL_TIMEOUT", c.Download.DialTimeout)
+	positive("DOWNLOAD_TLS_TIMEOUT", c.Download.TLSHandshakeTimeout)
+	positive("DOWNLOAD_RESPONSE_HEADER_TIMEOUT", c.Download.ResponseHeaderTimeout)
+	notNegative("DOWNLOAD_IDLE_CONN_TIMEOUT", c.Download.IdleConnTimeout)
+	notNegative("DOWNLOAD_KEEP_ALIVE", c.Download.KeepAlive)
+
+	check(c.Resize.Workers >= 0, "RESIZE_WORKERS must not be negative, got %d", c.Resize.Workers)
+	check(c.Resize.QueueSize >= 0, "RESIZE_QUEUE_SIZE must not be negative, got %d", c.Resize.QueueSize)
+
+	oneOf("TRACING_EXPORTER", c.Tracing.Exporter, "none", "stdout", "otlp")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1,
+		"TRACING_SAMPLE_RATIO must be in range 0-1, got %v", c.Tracing.SampleRatio)
+
+	check(c.Cache.MaxItems > 0, "CACHE_ITEMS must be positive, got %d", c.Cache.MaxItems)
+	if err := checkWritable(c.Cache.Path); err != nil {
+		errs = append(errs, fmt.Errorf("CACHE_PATH is not writable: %w", err))
+	}
+	notNegative("NEGATIVE_CACHE_TTL", c.Cache.NegativeTTL)
+	check(c.Cache.NegativeTTL == 0 || c.Cache.NegativeMaxItems > 0, "NEGATIVE_CACHE_ITEMS must be positive")
+	check(c.Cache.FanOut >= 0 && c.Cache.FanOut <= 4, "CACHE_FAN_OUT must be in range 0-4, got %d", c.Cache.FanOut)
+	check(c.Cache.WarmupConcurrency > 0, "CACHE_WARMUP_CONCURRENCY must be positive, got %d", c.Cache.WarmupConcurrency)
+	oneOf("CACHE_CREDENTIALS_POLICY", c.Cache.CredentialsPolicy, "partition", "bypass")
+
+	oneOf("RATE_LIMIT_KEY", c.Limits.KeyBy, "ip", "api_key")
+	positive("API_USAGE_FLUSH_INTERVAL", c.APIKeys.UsageFlushInterval)
+
+	return errors.Join(errs...)
+}
+
+// checkWritable checks that dir, or the closest existing parent it would be created in, is a writable directory.
+// It doesn't create anything, the cache creates the directory on start.
+func checkWritable(dir string) error {
+	if dir == "" {
+		return errors.New("path is empty")
+	}
+
+	path := filepath.Clean(dir)
+	for {
+		info, err := os.Stat(path)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", path)
+			}
+			return canWrite(path)
+		}
+		parent := filepath.Dir(path)
+		if !errors.Is(err, fs.ErrNotExist) || parent == path {
+			return err
+		}
+		path = parent
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd)
+
+package config
+
+// canWrite can't check permissions on this platform, the cache reports the error on start.
+func canWrite(string) error {
+	return nil
+}
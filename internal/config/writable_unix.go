@@ -0,0 +1,12 @@
+//go:build linux || darwin || freebsd
+
+package config
+
+import "syscall"
+
+// canWrite checks the permissions of dir for the current user.
+func canWrite(dir string) error {
+	const wOK = 0x2
+
+	return syscall.Access(dir, wOK)
+}
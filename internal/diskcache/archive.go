@@ -0,0 +1,307 @@
+package diskcache
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/esavich/otus_project/internal/logger"
+)
+
+const (
+	archiveVersion = 1
+	// the manifest is written last, so a truncated archive is detected
+	manifestName = "manifest.json"
+	// larger files in an archive are rejected
+	maxArchiveFile = 64 << 20
+)
+
+type manifest struct {
+	Version int       `json:"version"`
+	Entries int       `json:"entries"`
+	Created time.Time `json:"created"`
+}
+
+// ArchiveStats describes an exported or imported archive.
+type ArchiveStats struct {
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+	// corrupted entries left out
+	Skipped int `json:"skipped"`
+}
+
+// Export writes entries of the cache dir to a tar archive from the oldest to the newest,
+// entries failing the checksum are skipped and private entries are left out.
+// The cache dir must not be changed at the same time.
+func Export(dir string, w io.Writer) (ArchiveStats, error) {
+	listing, err := List(dir)
+	if err != nil {
+		return ArchiveStats{}, err
+	}
+
+	var stats ArchiveStats
+	tw := tar.NewWriter(w)
+	for _, e := range listing.Entries {
+		if e.Private {
+			continue
+		}
+		data, err := os.ReadFile(e.Path)
+		if err != nil || checksum(data) != e.Checksum {
+			stats.Skipped++
+			continue
+		}
+		meta, err := json.Marshal(e.Meta)
+		if err != nil {
+			return stats, err
+		}
+		name := strings.TrimSuffix(filepath.Base(e.Path), imageExt)
+		if err := writeTarFile(tw, name+metaSuffix, meta, e.Created); err != nil {
+			return stats, err
+		}
+		if err := writeTarFile(tw, name+imageExt, data, e.Created); err != nil {
+			return stats, err
+		}
+		stats.Entries++
+		stats.Bytes += e.Size
+	}
+
+	m, err := json.Marshal(manifest{Version: archiveVersion, Entries: stats.Entries, Created: time.Now().UTC()})
+	if err != nil {
+		return stats, err
+	}
+	if err := writeTarFile(tw, manifestName, m, time.Now()); err != nil {
+		return stats, err
+	}
+
+	return stats, tw.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0o600,
+		Size:     int64(len(data)),
+		ModTime:  modTime,
+		Typeflag: tar.TypeReg,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+
+	return err
+}
+
+// readArchive calls fn for every entry whose image matches the size and checksum of its meta,
+// other entries are skipped. It fails on a malformed or truncated archive, entries passed to fn
+// before the failure stay.
+func readArchive(r io.Reader, fn func(meta Meta, data []byte) error) (ArchiveStats, error) {
+	var (
+		stats   ArchiveStats
+		pending *Meta
+		name    string
+		done    bool
+	)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return stats, fmt.Errorf("can't read archive: %w", err)
+		}
+		if done {
+			return stats, fmt.Errorf("unexpected %s after the manifest", hdr.Name)
+		}
+		if hdr.Typeflag != tar.TypeReg || hdr.Size > maxArchiveFile {
+			return stats, fmt.Errorf("unexpected archive entry %s", hdr.Name)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return stats, fmt.Errorf("can't read archive: %w", err)
+		}
+
+		switch {
+		case hdr.Name == manifestName:
+			var m manifest
+			if err := json.Unmarshal(data, &m); err != nil {
+				return stats, fmt.Errorf("invalid manifest: %w", err)
+			}
+			if m.Version != archiveVersion {
+				return stats, fmt.Errorf("unsupported archive version %d", m.Version)
+			}
+			if m.Entries != stats.Entries+stats.Skipped {
+				return stats, fmt.Errorf("archive has %d entries, the manifest lists %d", stats.Entries+stats.Skipped, m.Entries)
+			}
+			done = true
+		case strings.HasSuffix(hdr.Name, metaSuffix):
+			pending, name = new(Meta), strings.TrimSuffix(hdr.Name, metaSuffix)
+			if err := json.Unmarshal(data, pending); err != nil {
+				pending = nil
+			}
+		case strings.HasSuffix(hdr.Name, imageExt):
+			meta := pending
+			pending = nil
+			if meta == nil || meta.Private || name != strings.TrimSuffix(hdr.Name, imageExt) ||
+				int64(len(data)) != meta.Size || checksum(data) != meta.Checksum {
+				stats.Skipped++
+				continue
+			}
+			if err := fn(*meta, data); err != nil {
+				return stats, err
+			}
+			stats.Entries++
+			stats.Bytes += meta.Size
+		default:
+			return stats, fmt.Errorf("unexpected archive entry %s", hdr.Name)
+		}
+	}
+	if !done {
+		return stats, errors.New("archive is truncated, the manifest is missing")
+	}
+
+	return stats, nil
+}
+
+// Import writes entries of a tar archive to the cache dir of a stopped server with the given fan-out,
+// entries with the same keys are replaced.
+func Import(dir string, fanOut int, r io.Reader) (ArchiveStats, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return ArchiveStats{}, fmt.Errorf("can't create or open cache dir: %w", err)
+	}
+
+	return readArchive(r, func(meta Meta, data []byte) error {
+		path := filePath(dir, meta.Key, fanOut)
+		if err := makeParent(path); err != nil {
+			return err
+		}
+		_, err := writeFiles(path, data, meta)
+		return err
+	})
+}
+
+// Import loads entries of a tar archive into the running cache, older entries are evicted
+// when the archive has more than the capacity.
+func (dc *Wrapper) Import(ctx context.Context, r io.Reader) (ArchiveStats, error) {
+	log := logger.FromContext(ctx)
+
+	return readArchive(r, func(meta Meta, data []byte) error {
+		evicted, err := dc.write(data, meta)
+		if err != nil {
+			return err
+		}
+		dc.removeEvicted(log, evicted)
+		return nil
+	})
+}
+
+// ExportFile exports the cache dir to path, compressed by the extension: .tar, .tar.gz, .tgz or .tar.zst.
+// The archive is written to a temporary file first, so path is left as is on failure.
+func ExportFile(dir, path string) (ArchiveStats, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), ".export-*")
+	if err != nil {
+		return ArchiveStats{}, fmt.Errorf("can't create archive: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w, err := compressor(f, path)
+	if err != nil {
+		return ArchiveStats{}, err
+	}
+	stats, err := Export(dir, w)
+	if err != nil {
+		return stats, err
+	}
+	if err := w.Close(); err != nil {
+		return stats, fmt.Errorf("can't write archive: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return stats, fmt.Errorf("can't write archive: %w", err)
+	}
+
+	return stats, os.Rename(f.Name(), path)
+}
+
+// ImportFile imports an archive written by ExportFile into the cache dir of a stopped server.
+func ImportFile(dir string, fanOut int, path string) (ArchiveStats, error) {
+	return readArchiveFile(path, func(r io.Reader) (ArchiveStats, error) {
+		return Import(dir, fanOut, r)
+	})
+}
+
+// ImportFile loads an archive written by ExportFile into the running cache.
+func (dc *Wrapper) ImportFile(ctx context.Context, path string) (ArchiveStats, error) {
+	return readArchiveFile(path, func(r io.Reader) (ArchiveStats, error) {
+		return dc.Import(ctx, r)
+	})
+}
+
+func readArchiveFile(path string, fn func(r io.Reader) (ArchiveStats, error)) (ArchiveStats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ArchiveStats{}, fmt.Errorf("can't open archive: %w", err)
+	}
+	defer f.Close()
+
+	r, err := decompressor(f, path)
+	if err != nil {
+		return ArchiveStats{}, err
+	}
+	defer r.Close()
+
+	return fn(r)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func compressor(w io.Writer, path string) (io.WriteCloser, error) {
+	switch {
+	case strings.HasSuffix(path, ".tar.gz"), strings.HasSuffix(path, ".tgz"):
+		return gzip.NewWriter(w), nil
+	case strings.HasSuffix(path, ".tar.zst"):
+		return zstd.NewWriter(w)
+	case strings.HasSuffix(path, ".tar"):
+		return nopWriteCloser{w}, nil
+	default:
+		return nil, fmt.Errorf("unknown archive type %s, expected .tar, .tar.gz, .tgz or .tar.zst", path)
+	}
+}
+
+func decompressor(r io.Reader, path string) (io.ReadCloser, error) {
+	switch {
+	case strings.HasSuffix(path, ".tar.gz"), strings.HasSuffix(path, ".tgz"):
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("can't read archive: %w", err)
+		}
+		return zr, nil
+	case strings.HasSuffix(path, ".tar.zst"):
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("can't read archive: %w", err)
+		}
+		return zr.IOReadCloser(), nil
+	case strings.HasSuffix(path, ".tar"):
+		return io.NopCloser(r), nil
+	default:
+		return nil, fmt.Errorf("unknown archive type %s, expected .tar, .tar.gz, .tgz or .tar.zst", path)
+	}
+}
@@ -0,0 +1,114 @@
+package diskcache
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImport(t *testing.T) {
+	for _, name := range []string{"cache.tar", "cache.tar.gz", "cache.tar.zst"} {
+		t.Run(name, func(t *testing.T) {
+			src := t.TempDir()
+			fillDir(t, src, "key1", "key2", "key3")
+			archive := filepath.Join(t.TempDir(), name)
+
+			stats, err := ExportFile(src, archive)
+			require.NoError(t, err)
+			require.Equal(t, 3, stats.Entries)
+			require.Zero(t, stats.Skipped)
+
+			dst := filepath.Join(t.TempDir(), "cache")
+			imported, err := ImportFile(dst, 2, archive)
+			require.NoError(t, err)
+			require.Equal(t, stats, imported)
+
+			want, err := List(src)
+			require.NoError(t, err)
+			got, err := List(dst)
+			require.NoError(t, err)
+			require.Len(t, got.Entries, 3)
+			for i := range got.Entries {
+				require.Equal(t, want.Entries[i].Meta, got.Entries[i].Meta)
+			}
+			corrupted, err := Verify(dst)
+			require.NoError(t, err)
+			require.Empty(t, corrupted)
+		})
+	}
+}
+
+func TestExport_SkipsCorrupted(t *testing.T) {
+	dir := t.TempDir()
+	cache := fillDir(t, dir, "key1", "key2")
+	require.NoError(t, os.WriteFile(cache.getFilePath("key1"), []byte("broken"), 0o600))
+
+	var buf bytes.Buffer
+	stats, err := Export(dir, &buf)
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.Entries)
+	require.Equal(t, 1, stats.Skipped)
+
+	imported, err := Import(t.TempDir(), 0, &buf)
+	require.NoError(t, err)
+	require.Equal(t, 1, imported.Entries)
+}
+
+func TestExport_SkipsPrivate(t *testing.T) {
+	dir := t.TempDir()
+	cache := fillDir(t, dir, "key1")
+	require.NoError(t, cache.SetPrivate(context.Background(), "key2", testSource, createTestImage()))
+
+	var buf bytes.Buffer
+	stats, err := Export(dir, &buf)
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.Entries)
+	require.Zero(t, stats.Skipped)
+
+	dst := t.TempDir()
+	_, err = Import(dst, 0, &buf)
+	require.NoError(t, err)
+	listing, err := List(dst)
+	require.NoError(t, err)
+	require.Len(t, listing.Entries, 1)
+	require.Equal(t, "key1", listing.Entries[0].Key)
+}
+
+func TestImport_Truncated(t *testing.T) {
+	dir := t.TempDir()
+	fillDir(t, dir, "key1", "key2")
+
+	var buf bytes.Buffer
+	_, err := Export(dir, &buf)
+	require.NoError(t, err)
+
+	// cut off the manifest
+	truncated := buf.Bytes()[:buf.Len()/2]
+	_, err = Import(t.TempDir(), 0, bytes.NewReader(truncated))
+	require.Error(t, err)
+}
+
+func TestWrapperImport(t *testing.T) {
+	src := t.TempDir()
+	fillDir(t, src, "key1", "key2", "key3")
+	var buf bytes.Buffer
+	_, err := Export(src, &buf)
+	require.NoError(t, err)
+
+	cache, err := NewDiskCacheWrapper(2, t.TempDir())
+	require.NoError(t, err)
+	stats, err := cache.Import(context.Background(), &buf)
+	require.NoError(t, err)
+	require.Equal(t, 3, stats.Entries)
+
+	// the oldest entry is evicted
+	require.Equal(t, int64(2), cache.Stats().Items)
+	_, ok := cache.Get(context.Background(), "key1")
+	require.False(t, ok)
+	_, ok = cache.Get(context.Background(), "key3")
+	require.True(t, ok)
+}
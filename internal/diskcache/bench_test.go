@@ -0,0 +1,122 @@
+package diskcache
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+const benchKeys = 64
+
+// benchImage is noisy, so jpeg encoding and decoding cost about as much as for a photo.
+func benchImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 200))
+	rnd := rand.New(rand.NewPCG(1, 2))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(rnd.IntN(256))
+	}
+	img.Set(0, 0, color.White)
+
+	return img
+}
+
+func newBenchCache(b *testing.B) *Wrapper {
+	b.Helper()
+
+	cache, err := NewDiskCacheWrapper(benchKeys*2, b.TempDir())
+	if err != nil {
+		b.Fatal(err)
+	}
+	img := benchImage()
+	for i := range benchKeys {
+		if err := cache.Set(context.Background(), strconv.Itoa(i), testSource, img); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	return cache
+}
+
+type benchCache interface {
+	Get(ctx context.Context, key string) (image.Image, bool)
+	Set(ctx context.Context, key, source string, data image.Image) error
+}
+
+// globalLock serializes every operation like the cache did before the key locks,
+// when a single mutex was held across encoding, file io and decoding.
+type globalLock struct {
+	mutex sync.Mutex
+	cache *Wrapper
+}
+
+func (g *globalLock) Get(ctx context.Context, key string) (image.Image, bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.cache.Get(ctx, key)
+}
+
+func (g *globalLock) Set(ctx context.Context, key, source string, data image.Image) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.cache.Set(ctx, key, source, data)
+}
+
+// runLocking runs bench with the key locks and with a single mutex around every operation.
+func runLocking(b *testing.B, bench func(b *testing.B, cache benchCache)) {
+	b.Helper()
+
+	b.Run("keylocks", func(b *testing.B) {
+		bench(b, newBenchCache(b))
+	})
+	b.Run("mutex", func(b *testing.B) {
+		bench(b, &globalLock{cache: newBenchCache(b)})
+	})
+}
+
+// BenchmarkGet_Parallel reads cached images from all CPUs.
+func BenchmarkGet_Parallel(b *testing.B) {
+	runLocking(b, func(b *testing.B, cache benchCache) {
+		ctx := context.Background()
+
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			i := rand.IntN(benchKeys)
+			for pb.Next() {
+				if _, ok := cache.Get(ctx, strconv.Itoa(i%benchKeys)); !ok {
+					b.Error("cache miss")
+				}
+				i++
+			}
+		})
+	})
+}
+
+// BenchmarkMixed_Parallel reads cached images while every tenth operation writes one.
+func BenchmarkMixed_Parallel(b *testing.B) {
+	runLocking(b, func(b *testing.B, cache benchCache) {
+		ctx := context.Background()
+		img := benchImage()
+
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			i := rand.IntN(benchKeys)
+			for pb.Next() {
+				key := strconv.Itoa(i % benchKeys)
+				if i%10 == 0 {
+					if err := cache.Set(ctx, key, testSource, img); err != nil {
+						b.Error(err)
+					}
+				} else {
+					cache.Get(ctx, key)
+				}
+				i++
+			}
+		})
+	})
+}
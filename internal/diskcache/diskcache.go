@@ -2,37 +2,125 @@ package diskcache
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"image"
 	"image/jpeg"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
 
 	"github.com/esavich/otus_project/internal/cache"
+	"github.com/esavich/otus_project/internal/logger"
 )
 
+var tracer = otel.Tracer("github.com/esavich/otus_project/internal/diskcache")
+
+// Stats is a snapshot of cache counters.
+type Stats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Corrupted uint64 `json:"corrupted"`
+	Items     int64  `json:"items"`
+	Bytes     int64  `json:"bytes"`
+}
+
+type entry struct {
+	key      string
+	source   string
+	path     string
+	size     int64
+	checksum string
+}
+
+// Entry describes a cached image.
+type Entry struct {
+	Key    string `json:"key"`
+	Source string `json:"source"`
+	Size   int64  `json:"size"`
+}
+
 type Wrapper struct {
-	memCache cache.Cache
-	basePath string
-	mutex    sync.Mutex
+	memCache   cache.Cache[string, entry]
+	basePath   string
+	persistent bool
+	// levels of two hex char subdirectories, e.g. ab/cd/abcd...jpg for 2
+	fanOut int
+	// locks guard files of keys, a key lock is taken before the mutex and no file io happens under the mutex
+	locks [keyLocks]sync.RWMutex
+	// mutex guards the index: memCache together with sources and counters
+	mutex sync.Mutex
+	// source url to keys of all its variants
+	sources map[string]map[string]struct{}
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+	corrupted atomic.Uint64
+	items     atomic.Int64
+	bytes     atomic.Int64
+}
+
+const (
+	// maxFanOut is the deepest supported directory layout.
+	maxFanOut = 4
+	// operations on keys of different lock stripes don't wait for each other
+	keyLocks = 256
+	// lookups of keys in different index shards don't wait for each other
+	indexShards = 16
+)
+
+var errNotCached = errors.New("not cached")
+
+type Option func(*Wrapper)
+
+// WithPersistence keeps cached files between restarts, the index is rebuilt from meta files on start.
+func WithPersistence() Option {
+	return func(dc *Wrapper) {
+		dc.persistent = true
+	}
 }
 
-func NewDiskCacheWrapper(capacity int, diskPath string) (*Wrapper, error) {
+// WithFanOut spreads files over levels of subdirectories named by hash prefixes,
+// so directories stay small with many entries. Zero keeps all files in the cache dir.
+func WithFanOut(levels int) Option {
+	return func(dc *Wrapper) {
+		dc.fanOut = max(levels, 0)
+	}
+}
+
+// NewDiskCacheWrapper keeps up to capacity images in diskPath. The index is sharded, see cache.NewSharded,
+// so eviction follows the LRU order of a shard and only approximates the order of the whole cache.
+func NewDiskCacheWrapper(capacity int, diskPath string, opts ...Option) (*Wrapper, error) {
 	err := os.MkdirAll(diskPath, 0o755)
 	if err != nil {
 		return nil, fmt.Errorf("can't create or open cache dir: %w", err)
 	}
 
 	wrapper := &Wrapper{
-		memCache: cache.NewCache(capacity),
+		memCache: cache.NewSharded[string, entry](capacity, indexShards),
 		basePath: diskPath,
+		sources:  make(map[string]map[string]struct{}),
+	}
+	for _, opt := range opts {
+		opt(wrapper)
 	}
 
-	err = wrapper.ClearDiskCache()
+	if wrapper.persistent {
+		err = wrapper.load()
+	} else {
+		err = wrapper.ClearDiskCache()
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -40,76 +128,343 @@ func NewDiskCacheWrapper(capacity int, diskPath string) (*Wrapper, error) {
 	return wrapper, nil
 }
 
-func (dc *Wrapper) Set(key string, data image.Image) error {
-	dc.mutex.Lock()
-	defer dc.mutex.Unlock()
+// Persistent reports whether cached files are kept between restarts.
+func (dc *Wrapper) Persistent() bool {
+	return dc.persistent
+}
+
+// load rebuilds the index from the cache directory, the most recently written entries are kept
+// when there are more than the capacity. Files of another fan-out are moved to the current layout.
+func (dc *Wrapper) load() error {
+	listing, err := List(dc.basePath)
+	if err != nil {
+		return err
+	}
+
+	private := 0
+	for _, e := range listing.Entries {
+		// keys of private entries were partitioned by a previous process, they would never be hit again
+		if e.Private {
+			if err := e.Remove(); err != nil {
+				slog.Error(fmt.Sprintf("Can't remove private entry %s: %s", e.Path, err))
+			}
+			private++
+			continue
+		}
+		path := dc.getFilePath(e.Key)
+		if path != e.Path {
+			if err := moveFiles(e.Path, path); err != nil {
+				slog.Error(fmt.Sprintf("Can't move file %s to %s: %s", e.Path, path, err))
+				path = e.Path
+			}
+		}
+		dc.mutex.Lock()
+		evicted := dc.store(entry{key: e.Key, source: e.Source, path: path, size: e.Size, checksum: e.Checksum})
+		dc.mutex.Unlock()
+		dc.removeEvicted(slog.Default(), evicted)
+	}
+	slog.Info(fmt.Sprintf("Loaded %d cached images, removed %d private ones, %d orphaned files",
+		dc.items.Load(), private, len(listing.Orphans)))
+
+	return nil
+}
+
+// Set stores the image under key, source is the url of the original image.
+func (dc *Wrapper) Set(ctx context.Context, key, source string, data image.Image) error {
+	return dc.set(ctx, Meta{Key: key, Source: source}, data)
+}
+
+// SetPrivate stores the image of a request with credentials. It is served like any other entry,
+// but it is removed on the next start and left out of exports.
+func (dc *Wrapper) SetPrivate(ctx context.Context, key, source string, data image.Image) error {
+	return dc.set(ctx, Meta{Key: key, Source: source, Private: true}, data)
+}
 
-	filePath := dc.getFilePath(key)
+func (dc *Wrapper) set(ctx context.Context, meta Meta, data image.Image) error {
+	ctx, span := tracer.Start(ctx, "diskcache.Set")
+	defer span.End()
+	log := logger.FromContext(ctx)
 
-	outFile, err := os.Create(filePath)
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, data, nil)
 	if err != nil {
-		slog.Error(err.Error())
+		log.Error(err.Error())
 		return err
 	}
-	defer outFile.Close()
-	err = jpeg.Encode(outFile, data, nil)
+	meta.Created = time.Now().UTC()
+	evicted, err := dc.write(buf.Bytes(), meta)
 	if err != nil {
-		slog.Error(err.Error())
+		log.Error(err.Error())
 		return err
 	}
+	dc.removeEvicted(log, evicted)
 
-	// func to remove file from disk
-	removeCallback := func(value interface{}) {
-		fileToDeletePath := value.(string)
-		slog.Error(fmt.Sprintf("Removing file: %s", fileToDeletePath))
-		err := os.Remove(fileToDeletePath)
-		if err != nil {
-			slog.Error(fmt.Sprintf("Can't remove file %s: %s", fileToDeletePath, err))
+	return nil
+}
+
+// write replaces files of the key and indexes them under the key lock, it returns evicted entries.
+func (dc *Wrapper) write(data []byte, meta Meta) ([]entry, error) {
+	lock := dc.keyLock(meta.Key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	path := dc.getFilePath(meta.Key)
+	if dc.fanOut > 0 {
+		if err := makeParent(path); err != nil {
+			return nil, err
 		}
 	}
+	meta, err := writeFiles(path, data, meta)
+	if err != nil {
+		return nil, err
+	}
 
-	dc.memCache.Set(cache.Key(key), filePath, removeCallback)
+	dc.mutex.Lock()
+	defer dc.mutex.Unlock()
 
-	return nil
+	return dc.store(entry{key: meta.Key, source: meta.Source, path: path, size: meta.Size, checksum: meta.Checksum}), nil
 }
 
-func (dc *Wrapper) Get(key string) (image.Image, bool) {
-	dc.mutex.Lock()
-	defer dc.mutex.Unlock()
+// store adds the entry to the index and returns evicted entries, whose files are still on disk.
+// The caller holds the mutex.
+func (dc *Wrapper) store(e entry) []entry {
+	// the file was overwritten, forget the old entry
+	if prev, found := dc.memCache.Peek(e.key); found {
+		dc.forget(prev)
+	}
 
-	cachedPath, found := dc.memCache.Get(cache.Key(key))
-	if !found {
-		return nil, false
+	var evicted []entry
+	dc.memCache.Set(e.key, e, func(old entry) {
+		dc.evictions.Add(1)
+		dc.forget(old)
+		evicted = append(evicted, old)
+	})
+	dc.remember(e)
+
+	return evicted
+}
+
+// removeEvicted removes files of evicted entries unless their keys were stored again meanwhile.
+// The caller holds no locks.
+func (dc *Wrapper) removeEvicted(log *slog.Logger, evicted []entry) {
+	for _, e := range evicted {
+		lock := dc.keyLock(e.key)
+		lock.Lock()
+		if _, stored := dc.memCache.Peek(e.key); !stored {
+			log.Error(fmt.Sprintf("Removing file: %s", e.path))
+			err := removeFiles(e.path)
+			if err != nil {
+				log.Error(fmt.Sprintf("Can't remove file %s: %s", e.path, err))
+			}
+		}
+		lock.Unlock()
 	}
+}
 
-	filePath, ok := cachedPath.(string)
-	if !ok {
-		slog.Error("Cache value is not a string")
+func (dc *Wrapper) Get(ctx context.Context, key string) (image.Image, bool) {
+	ctx, span := tracer.Start(ctx, "diskcache.Get")
+	defer span.End()
+	log := logger.FromContext(ctx)
+
+	e, data, err := dc.read(key)
+	if errors.Is(err, errNotCached) {
+		dc.misses.Add(1)
 		return nil, false
 	}
-	data, err := os.ReadFile(filePath)
 	if err != nil {
-		slog.Error(fmt.Sprintf("Can't read file %s from disk: %s", filePath, err))
+		log.Error(fmt.Sprintf("Can't read file %s from disk: %s", e.path, err))
+		dc.misses.Add(1)
+		return nil, false
+	}
+	// a file damaged on disk is evicted, so the image is downloaded and written again
+	if checksum(data) != e.checksum {
+		log.Error(fmt.Sprintf("Checksum mismatch of file %s, evicting %s", e.path, key))
+		dc.remove(ctx, key, func(current entry) bool { return current.checksum == e.checksum })
+		dc.corrupted.Add(1)
+		dc.misses.Add(1)
 		return nil, false
 	}
 	img, err := jpeg.Decode(bytes.NewReader(data))
 	if err != nil {
-		slog.Error(fmt.Sprintf("Can't decode jpeg: %s", err))
+		log.Error(fmt.Sprintf("Can't decode jpeg: %s", err))
+		dc.misses.Add(1)
 		return nil, false
 	}
+	dc.hits.Add(1)
 	return img, true
 }
 
+// read returns the entry and content of its file under the read lock of the key,
+// checking and decoding is left to the caller without locks.
+func (dc *Wrapper) read(key string) (entry, []byte, error) {
+	lock := dc.keyLock(key)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	e, found := dc.memCache.Get(key)
+	if !found {
+		return entry{}, nil, errNotCached
+	}
+	data, err := os.ReadFile(e.path)
+
+	return e, data, err
+}
+
+func (dc *Wrapper) keyLock(key string) *sync.RWMutex {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+
+	return &dc.locks[h.Sum32()%keyLocks]
+}
+
+// remember adds the entry to counters and the source index, the caller holds the mutex.
+func (dc *Wrapper) remember(e entry) {
+	dc.items.Add(1)
+	dc.bytes.Add(e.size)
+
+	keys, ok := dc.sources[e.source]
+	if !ok {
+		keys = make(map[string]struct{})
+		dc.sources[e.source] = keys
+	}
+	keys[e.key] = struct{}{}
+}
+
+// forget removes the entry from counters and the source index, the caller holds the mutex.
+func (dc *Wrapper) forget(e entry) {
+	dc.items.Add(-1)
+	dc.bytes.Add(-e.size)
+
+	keys := dc.sources[e.source]
+	delete(keys, e.key)
+	if len(keys) == 0 {
+		delete(dc.sources, e.source)
+	}
+}
+
+// Entries returns cached entries from the most to the least recently used, matching the filter if it is not nil.
+// Zero limit means all entries.
+func (dc *Wrapper) Entries(filter func(Entry) bool, limit int) []Entry {
+	var entries []Entry
+	dc.memCache.Range(func(_ string, e entry) bool {
+		info := Entry{Key: e.key, Source: e.source, Size: e.size}
+		if filter == nil || filter(info) {
+			entries = append(entries, info)
+		}
+		return limit <= 0 || len(entries) < limit
+	})
+
+	return entries
+}
+
+// Remove deletes one cached entry.
+func (dc *Wrapper) Remove(ctx context.Context, key string) bool {
+	return dc.remove(ctx, key, nil)
+}
+
+// PurgeSource deletes all cached variants of the source url.
+func (dc *Wrapper) PurgeSource(ctx context.Context, source string) int {
+	dc.mutex.Lock()
+	keys := make([]string, 0, len(dc.sources[source]))
+	for key := range dc.sources[source] {
+		keys = append(keys, key)
+	}
+	dc.mutex.Unlock()
+
+	purged := 0
+	for _, key := range keys {
+		if dc.remove(ctx, key, nil) {
+			purged++
+		}
+	}
+
+	return purged
+}
+
+// Purge deletes all entries matching the filter.
+func (dc *Wrapper) Purge(ctx context.Context, filter func(Entry) bool) int {
+	purged := 0
+	for _, e := range dc.Entries(filter, 0) {
+		if dc.remove(ctx, e.Key, nil) {
+			purged++
+		}
+	}
+
+	return purged
+}
+
+// remove deletes the entry and its files if match is nil or accepts the current entry.
+func (dc *Wrapper) remove(ctx context.Context, key string, match func(entry) bool) bool {
+	lock := dc.keyLock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	dc.mutex.Lock()
+	e, found := dc.memCache.Peek(key)
+	if !found || (match != nil && !match(e)) {
+		dc.mutex.Unlock()
+		return false
+	}
+	dc.memCache.Remove(key)
+	dc.forget(e)
+	dc.mutex.Unlock()
+
+	err := removeFiles(e.path)
+	if err != nil {
+		logger.FromContext(ctx).Error(fmt.Sprintf("Can't remove file %s: %s", e.path, err))
+	}
+
+	return true
+}
+
+func (dc *Wrapper) Stats() Stats {
+	return Stats{
+		Hits:      dc.hits.Load(),
+		Misses:    dc.misses.Load(),
+		Evictions: dc.evictions.Load(),
+		Corrupted: dc.corrupted.Load(),
+		Items:     dc.items.Load(),
+		Bytes:     dc.bytes.Load(),
+	}
+}
+
 func (dc *Wrapper) getFilePath(key string) string {
+	return filePath(dc.basePath, key, dc.fanOut)
+}
+
+func filePath(dir, key string, fanOut int) string {
 	// hash name to avoid long names and special symbols compatibility problems
 	h := sha256.New()
 	h.Write([]byte(key))
 	hash := hex.EncodeToString(h.Sum(nil))
 
-	return filepath.Join(dc.basePath, fmt.Sprintf("%s.jpg", hash))
+	parts := []string{dir}
+	for level := range min(fanOut, maxFanOut) {
+		parts = append(parts, hash[2*level:2*level+2])
+	}
+	parts = append(parts, fmt.Sprintf("%s.jpg", hash))
+
+	return filepath.Join(parts...)
 }
 
 func (dc *Wrapper) ClearDiskCache() error {
+	// nothing else runs while the whole cache is cleared
+	for i := range dc.locks {
+		dc.locks[i].Lock()
+	}
+	defer func() {
+		for i := range dc.locks {
+			dc.locks[i].Unlock()
+		}
+	}()
+	dc.mutex.Lock()
+	defer dc.mutex.Unlock()
+
+	dc.memCache.Clear()
+	dc.sources = make(map[string]map[string]struct{})
+	dc.items.Store(0)
+	dc.bytes.Store(0)
+
 	d, err := os.Open(dc.basePath)
 	if err != nil {
 		return fmt.Errorf("can't open cache dir: %w", err)
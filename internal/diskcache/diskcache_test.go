@@ -1,15 +1,22 @@
 package diskcache
 
 import (
+	"context"
 	"image"
 	"image/color"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+const testSource = "http://example.com/image.jpg"
+
 func createTestImage() image.Image {
 	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
 	img.Set(0, 0, color.RGBA{255, 255, 255, 255})
@@ -24,10 +31,10 @@ func TestSetAndGet(t *testing.T) {
 
 	img := createTestImage()
 	key := "test-key"
-	err = cache.Set(key, img)
+	err = cache.Set(context.Background(), key, testSource, img)
 	require.NoError(t, err)
 
-	gotImg, ok := cache.Get(key)
+	gotImg, ok := cache.Get(context.Background(), key)
 	require.True(t, ok)
 	require.NotNil(t, gotImg)
 }
@@ -37,7 +44,7 @@ func TestGetNotFound(t *testing.T) {
 	cache, err := NewDiskCacheWrapper(2, dir)
 	require.NoError(t, err)
 
-	_, ok := cache.Get("not-exist")
+	_, ok := cache.Get(context.Background(), "not-exist")
 	require.False(t, ok)
 }
 
@@ -48,7 +55,7 @@ func TestClearDiskCache(t *testing.T) {
 
 	img := createTestImage()
 	key := "clear-key"
-	err = cache.Set(key, img)
+	err = cache.Set(context.Background(), key, testSource, img)
 	require.NoError(t, err)
 
 	filePath := cache.getFilePath(key)
@@ -78,7 +85,7 @@ func TestSetInvalidPath(t *testing.T) {
 		basePath: "/invalid/path/for/test",
 	}
 	img := createTestImage()
-	err := cache.Set("key", img)
+	err := cache.Set(context.Background(), "key", testSource, img)
 	require.Error(t, err)
 }
 
@@ -89,13 +96,261 @@ func TestSetAndOldCacheRemoving(t *testing.T) {
 
 	img1 := createTestImage()
 	img2 := createTestImage()
-	err = cache.Set("key1", img1)
+	err = cache.Set(context.Background(), "key1", testSource, img1)
+	require.NoError(t, err)
+	err = cache.Set(context.Background(), "key2", testSource, img2)
+	require.NoError(t, err)
+
+	_, ok := cache.Get(context.Background(), "key1")
+	require.False(t, ok)
+	_, ok = cache.Get(context.Background(), "key2")
+	require.True(t, ok)
+}
+
+func TestStats(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewDiskCacheWrapper(1, dir)
+	require.NoError(t, err)
+
+	require.NoError(t, cache.Set(context.Background(), "key1", testSource, createTestImage()))
+	_, ok := cache.Get(context.Background(), "key1")
+	require.True(t, ok)
+	_, ok = cache.Get(context.Background(), "not-exist")
+	require.False(t, ok)
+
+	stats := cache.Stats()
+	require.Equal(t, uint64(1), stats.Hits)
+	require.Equal(t, uint64(1), stats.Misses)
+	require.Equal(t, int64(1), stats.Items)
+	require.Positive(t, stats.Bytes)
+	size := stats.Bytes
+
+	// overwrite does not change the counters
+	require.NoError(t, cache.Set(context.Background(), "key1", testSource, createTestImage()))
+	require.Equal(t, int64(1), cache.Stats().Items)
+	require.Equal(t, size, cache.Stats().Bytes)
+
+	// key1 is evicted
+	require.NoError(t, cache.Set(context.Background(), "key2", testSource, createTestImage()))
+	stats = cache.Stats()
+	require.Equal(t, uint64(1), stats.Evictions)
+	require.Equal(t, int64(1), stats.Items)
+	require.Equal(t, size, stats.Bytes)
+
+	require.NoError(t, cache.ClearDiskCache())
+	require.Equal(t, int64(0), cache.Stats().Items)
+	require.Equal(t, int64(0), cache.Stats().Bytes)
+}
+
+func TestPurge(t *testing.T) {
+	tempDir := t.TempDir()
+	cache, err := NewDiskCacheWrapper(10, tempDir)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	sources := map[string]string{
+		"100-100-a": "http://a.example.com/a.jpg",
+		"200-200-a": "http://a.example.com/a.jpg",
+		"100-100-b": "http://a.example.com/img/b.jpg",
+		"100-100-c": "http://c.example.com/c.jpg",
+		"100-100-d": "http://d.example.com/d.jpg",
+	}
+	for key, source := range sources {
+		require.NoError(t, cache.Set(ctx, key, source, createTestImage()))
+	}
+	require.Len(t, cache.Entries(nil, 0), 5)
+	require.Len(t, cache.Entries(nil, 2), 2)
+
+	require.Equal(t, 2, cache.PurgeSource(ctx, "http://a.example.com/a.jpg"))
+	_, found := cache.Get(ctx, "200-200-a")
+	require.False(t, found)
+	require.Equal(t, 0, cache.PurgeSource(ctx, "http://a.example.com/a.jpg"))
+
+	require.True(t, cache.Remove(ctx, "100-100-d"))
+	require.False(t, cache.Remove(ctx, "100-100-d"))
+
+	purged := cache.Purge(ctx, func(e Entry) bool { return strings.HasPrefix(e.Source, "http://a.example.com/img/") })
+	require.Equal(t, 1, purged)
+
+	entries := cache.Entries(nil, 0)
+	require.Equal(t, []Entry{{Key: "100-100-c", Source: "http://c.example.com/c.jpg", Size: entries[0].Size}}, entries)
+	require.Equal(t, int64(1), cache.Stats().Items)
+	require.Equal(t, entries[0].Size, cache.Stats().Bytes)
+
+	// the image and its meta
+	files, err := os.ReadDir(tempDir)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+}
+
+func TestPersistence(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	cache, err := NewDiskCacheWrapper(2, dir, WithPersistence())
+	require.NoError(t, err)
+	for _, key := range []string{"key1", "key2", "key3"} {
+		require.NoError(t, cache.Set(ctx, key, testSource, createTestImage()))
+	}
+	stats := cache.Stats()
+
+	reopened, err := NewDiskCacheWrapper(2, dir, WithPersistence())
+	require.NoError(t, err)
+	require.Equal(t, stats.Items, reopened.Stats().Items)
+	require.Equal(t, stats.Bytes, reopened.Stats().Bytes)
+	_, ok := reopened.Get(ctx, "key3")
+	require.True(t, ok)
+	_, ok = reopened.Get(ctx, "key1")
+	require.False(t, ok)
+
+	// a smaller capacity keeps the newest entries
+	smaller, err := NewDiskCacheWrapper(1, dir, WithPersistence())
+	require.NoError(t, err)
+	require.Equal(t, []Entry{{Key: "key3", Source: testSource, Size: stats.Bytes / 2}}, smaller.Entries(nil, 0))
+
+	// without persistence the directory is cleared
+	cleared, err := NewDiskCacheWrapper(2, dir)
+	require.NoError(t, err)
+	require.Zero(t, cleared.Stats().Items)
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestPersistence_DropsPrivate(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	cache, err := NewDiskCacheWrapper(2, dir, WithPersistence())
+	require.NoError(t, err)
+	require.NoError(t, cache.Set(ctx, "public", testSource, createTestImage()))
+	require.NoError(t, cache.SetPrivate(ctx, "private", testSource, createTestImage()))
+	_, ok := cache.Get(ctx, "private")
+	require.True(t, ok)
+
+	reopened, err := NewDiskCacheWrapper(2, dir, WithPersistence())
+	require.NoError(t, err)
+	require.Equal(t, []Entry{{Key: "public", Source: testSource, Size: cache.Entries(nil, 0)[0].Size}},
+		reopened.Entries(nil, 0))
+	listing, err := List(dir)
 	require.NoError(t, err)
-	err = cache.Set("key2", img2)
+	require.Len(t, listing.Entries, 1)
+	require.Empty(t, listing.Orphans)
+}
+
+func TestGet_ChecksumMismatch(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewDiskCacheWrapper(2, dir)
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, cache.Set(ctx, "key1", testSource, createTestImage()))
+
+	// only the image and its meta, no temporary files
+	files, err := os.ReadDir(dir)
 	require.NoError(t, err)
+	require.Len(t, files, 2)
 
-	_, ok := cache.Get("key1")
+	// a still decodable but different image
+	path := cache.getFilePath("key1")
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, append(data, 0), 0o600))
+
+	_, ok := cache.Get(ctx, "key1")
+	require.False(t, ok)
+	stats := cache.Stats()
+	require.Equal(t, uint64(1), stats.Corrupted)
+	require.Equal(t, uint64(1), stats.Misses)
+	require.Zero(t, stats.Items)
+	files, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+
+	_, ok = cache.Get(ctx, "key1")
 	require.False(t, ok)
-	_, ok = cache.Get("key2")
+	require.Equal(t, uint64(1), cache.Stats().Corrupted)
+}
+
+func TestFanOut(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	cache, err := NewDiskCacheWrapper(10, dir, WithFanOut(2), WithPersistence())
+	require.NoError(t, err)
+
+	path := cache.getFilePath("key1")
+	hash := strings.TrimSuffix(filepath.Base(path), ".jpg")
+	require.Equal(t, filepath.Join(dir, hash[:2], hash[2:4], hash+".jpg"), path)
+
+	for _, key := range []string{"key1", "key2"} {
+		require.NoError(t, cache.Set(ctx, key, testSource, createTestImage()))
+	}
+	_, ok := cache.Get(ctx, "key1")
+	require.True(t, ok)
+	_, err = os.Stat(metaPath(path))
+	require.NoError(t, err)
+
+	// the index is rebuilt from the nested layout and files move to the flat one
+	flat, err := NewDiskCacheWrapper(10, dir, WithPersistence())
+	require.NoError(t, err)
+	require.Equal(t, int64(2), flat.Stats().Items)
+	_, ok = flat.Get(ctx, "key1")
 	require.True(t, ok)
+	_, err = os.Stat(flat.getFilePath("key1"))
+	require.NoError(t, err)
+	_, err = os.Stat(path)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// empty shard directories are cleaned up with orphans
+	_, err = RemoveOrphans(dir)
+	require.NoError(t, err)
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 4, "two images with meta and no directories")
+
+	// clearing removes nested directories
+	nested, err := NewDiskCacheWrapper(10, dir, WithFanOut(1), WithPersistence())
+	require.NoError(t, err)
+	require.Equal(t, int64(2), nested.Stats().Items)
+	require.NoError(t, nested.ClearDiskCache())
+	files, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewDiskCacheWrapper(8, dir, WithFanOut(1))
+	require.NoError(t, err)
+	ctx := context.Background()
+	img := createTestImage()
+
+	var wg sync.WaitGroup
+	for worker := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 100 {
+				key := strconv.Itoa((worker + i) % 16)
+				switch i % 5 {
+				case 0:
+					cache.Remove(ctx, key)
+				case 1, 2:
+					assert.NoError(t, cache.Set(ctx, key, testSource, img))
+				default:
+					cache.Get(ctx, key)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the index, counters and files agree
+	listing, err := List(dir)
+	require.NoError(t, err)
+	require.Empty(t, listing.Orphans)
+	require.Len(t, listing.Entries, len(cache.Entries(nil, 0)))
+	require.Equal(t, int64(len(listing.Entries)), cache.Stats().Items)
+	require.Equal(t, listing.Stats().Bytes, cache.Stats().Bytes)
+	for _, e := range cache.Entries(nil, 0) {
+		_, ok := cache.Get(ctx, e.Key)
+		require.True(t, ok, e.Key)
+	}
 }
@@ -0,0 +1,339 @@
+package diskcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	imageExt   = ".jpg"
+	metaSuffix = ".meta.json"
+	tmpPattern = ".tmp-*"
+)
+
+// Meta is stored next to every cached image, so the cache directory can be indexed
+// and checked without a running server.
+type Meta struct {
+	Key    string `json:"key"`
+	Source string `json:"source"`
+	Size   int64  `json:"size"`
+	// sha256 of the image file
+	Checksum string    `json:"checksum"`
+	Created  time.Time `json:"created"`
+	// cached for a request with credentials, such entries are dropped on start and never exported
+	Private bool `json:"private,omitempty"`
+}
+
+// DirEntry is a cached image found in the cache directory.
+type DirEntry struct {
+	Meta
+	Path string `json:"path"`
+}
+
+// Listing is the content of a cache directory.
+type Listing struct {
+	// from the oldest to the newest
+	Entries []DirEntry
+	// files that don't belong to any entry: images without meta, meta without images, leftovers
+	Orphans []string
+}
+
+// DirStats summarizes a cache directory.
+type DirStats struct {
+	Items   int       `json:"items"`
+	Bytes   int64     `json:"bytes"`
+	Orphans int       `json:"orphans"`
+	Oldest  time.Time `json:"oldest,omitzero"`
+	Newest  time.Time `json:"newest,omitzero"`
+}
+
+// Corruption is an entry whose image doesn't match its meta.
+type Corruption struct {
+	DirEntry
+	Reason string `json:"reason"`
+}
+
+func metaPath(imagePath string) string {
+	return strings.TrimSuffix(imagePath, imageExt) + metaSuffix
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// writeFiles writes the image and its meta, size and checksum of meta are filled from data.
+// Both files are written to temporary files first and then renamed, so a crash leaves either the old
+// or the new files and maybe temporary ones for RemoveOrphans, but never a truncated image.
+// When the meta can't be renamed the new image is removed too, so no meta is left describing another image.
+func writeFiles(path string, data []byte, meta Meta) (Meta, error) {
+	meta.Size = int64(len(data))
+	meta.Checksum = checksum(data)
+	encoded, err := json.Marshal(meta)
+	if err != nil {
+		return meta, err
+	}
+
+	imageTmp, err := writeTemp(path, data)
+	if err != nil {
+		return meta, err
+	}
+	metaTmp, err := writeTemp(metaPath(path), encoded)
+	if err != nil {
+		os.Remove(imageTmp)
+		return meta, err
+	}
+
+	// the image goes first, so a meta always describes a complete image
+	if err := os.Rename(imageTmp, path); err != nil {
+		os.Remove(imageTmp)
+		os.Remove(metaTmp)
+		return meta, err
+	}
+	if err := os.Rename(metaTmp, metaPath(path)); err != nil {
+		os.Remove(metaTmp)
+		return meta, errors.Join(err, removeFiles(path))
+	}
+
+	// the renames are durable only once the directory is synced
+	return meta, syncDir(filepath.Dir(path))
+}
+
+// writeTemp writes data to a synced temporary file in the dir of path and returns its name.
+func writeTemp(path string, data []byte) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), tmpPattern)
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return tmp.Name(), nil
+}
+
+// makeParent creates subdirectories of a nested layout.
+func makeParent(path string) error {
+	return os.MkdirAll(filepath.Dir(path), 0o755)
+}
+
+// moveFiles moves the image and its meta to another path of the same cache dir.
+func moveFiles(from, to string) error {
+	if err := makeParent(to); err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	if err := os.Rename(metaPath(from), metaPath(to)); err != nil {
+		return errors.Join(err, os.Rename(to, from))
+	}
+
+	return nil
+}
+
+// removeFiles removes the image and its meta, missing files are not an error.
+func removeFiles(path string) error {
+	var errs []error
+	for _, name := range []string{path, metaPath(path)} {
+		if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func readMeta(path string) (Meta, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Meta{}, err
+	}
+	var meta Meta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return Meta{}, fmt.Errorf("invalid meta %s: %w", path, err)
+	}
+
+	return meta, nil
+}
+
+// List reads the cache directory. It must not be changed by a running server at the same time.
+func List(dir string) (Listing, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return Listing{}, fmt.Errorf("can't read cache dir: %w", err)
+	}
+
+	listing := Listing{Entries: []DirEntry{}, Orphans: []string{}}
+	owned := make(map[string]bool)
+	for _, path := range files {
+		if !strings.HasSuffix(path, metaSuffix) {
+			continue
+		}
+		imagePath := strings.TrimSuffix(path, metaSuffix) + imageExt
+		meta, err := readMeta(path)
+		if err != nil {
+			continue
+		}
+		if _, err := os.Stat(imagePath); err != nil {
+			continue
+		}
+		owned[path] = true
+		owned[imagePath] = true
+		listing.Entries = append(listing.Entries, DirEntry{Meta: meta, Path: imagePath})
+	}
+	for _, path := range files {
+		if !owned[path] {
+			listing.Orphans = append(listing.Orphans, path)
+		}
+	}
+	slices.SortFunc(listing.Entries, func(a, b DirEntry) int {
+		if c := a.Created.Compare(b.Created); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Key, b.Key)
+	})
+
+	return listing, nil
+}
+
+func (l Listing) Stats() DirStats {
+	stats := DirStats{
+		Items:   len(l.Entries),
+		Orphans: len(l.Orphans),
+	}
+	for _, e := range l.Entries {
+		stats.Bytes += e.Size
+	}
+	if len(l.Entries) > 0 {
+		stats.Oldest = l.Entries[0].Created
+		stats.Newest = l.Entries[len(l.Entries)-1].Created
+	}
+
+	return stats
+}
+
+// Verify checks every image against the size and checksum of its meta.
+func Verify(dir string) ([]Corruption, error) {
+	listing, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	corrupted := []Corruption{}
+	for _, e := range listing.Entries {
+		data, err := os.ReadFile(e.Path)
+		switch {
+		case err != nil:
+			corrupted = append(corrupted, Corruption{DirEntry: e, Reason: err.Error()})
+		case int64(len(data)) != e.Size:
+			reason := fmt.Sprintf("size %d, expected %d", len(data), e.Size)
+			corrupted = append(corrupted, Corruption{DirEntry: e, Reason: reason})
+		case checksum(data) != e.Checksum:
+			corrupted = append(corrupted, Corruption{DirEntry: e, Reason: "checksum mismatch"})
+		}
+	}
+
+	return corrupted, nil
+}
+
+// Remove deletes files of the entry.
+func (e DirEntry) Remove() error {
+	return removeFiles(e.Path)
+}
+
+// Prune removes the oldest entries until images take at most budget bytes, it returns removed entries.
+func Prune(dir string, budget int64) ([]DirEntry, error) {
+	listing, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	total := listing.Stats().Bytes
+	removed := []DirEntry{}
+	for _, e := range listing.Entries {
+		if total <= budget {
+			break
+		}
+		if err := e.Remove(); err != nil {
+			return removed, err
+		}
+		total -= e.Size
+		removed = append(removed, e)
+	}
+
+	return removed, nil
+}
+
+// RemoveOrphans removes files that don't belong to any entry, it returns removed paths.
+func RemoveOrphans(dir string) ([]string, error) {
+	listing, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, path := range listing.Orphans {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return listing.Orphans[:i], err
+		}
+	}
+
+	return listing.Orphans, removeEmptyDirs(dir)
+}
+
+// removeEmptyDirs removes subdirectories left empty by a nested layout.
+func removeEmptyDirs(dir string) error {
+	var dirs []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && path != dir {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// children are walked after parents
+	for _, path := range slices.Backward(dirs) {
+		entries, err := os.ReadDir(path)
+		if err == nil && len(entries) == 0 {
+			err = os.Remove(path)
+		}
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package diskcache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fillDir writes entries with the keys in order and returns the cache.
+func fillDir(t *testing.T, dir string, keys ...string) *Wrapper {
+	t.Helper()
+
+	cache, err := NewDiskCacheWrapper(len(keys)+1, dir, WithPersistence())
+	require.NoError(t, err)
+	for _, key := range keys {
+		require.NoError(t, cache.Set(context.Background(), key, testSource, createTestImage()))
+	}
+
+	return cache
+}
+
+func TestList(t *testing.T) {
+	dir := t.TempDir()
+	cache := fillDir(t, dir, "key1", "key2")
+	orphanImage := filepath.Join(dir, "orphan.jpg")
+	require.NoError(t, os.WriteFile(orphanImage, []byte("data"), 0o600))
+	orphanMeta := metaPath(cache.getFilePath("key2"))
+	require.NoError(t, os.Remove(cache.getFilePath("key2")))
+
+	listing, err := List(dir)
+	require.NoError(t, err)
+	require.Len(t, listing.Entries, 1)
+	e := listing.Entries[0]
+	require.Equal(t, "key1", e.Key)
+	require.Equal(t, testSource, e.Source)
+	require.Equal(t, cache.getFilePath("key1"), e.Path)
+	require.Len(t, e.Checksum, 64)
+	require.ElementsMatch(t, []string{orphanImage, orphanMeta}, listing.Orphans)
+
+	stats := listing.Stats()
+	require.Equal(t, 1, stats.Items)
+	require.Equal(t, e.Size, stats.Bytes)
+	require.Equal(t, 2, stats.Orphans)
+	require.Equal(t, e.Created, stats.Newest)
+
+	removed, err := RemoveOrphans(dir)
+	require.NoError(t, err)
+	require.ElementsMatch(t, listing.Orphans, removed)
+	listing, err = List(dir)
+	require.NoError(t, err)
+	require.Len(t, listing.Entries, 1)
+	require.Empty(t, listing.Orphans)
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	cache := fillDir(t, dir, "key1", "key2", "key3")
+
+	corrupted, err := Verify(dir)
+	require.NoError(t, err)
+	require.Empty(t, corrupted)
+
+	data, err := os.ReadFile(cache.getFilePath("key1"))
+	require.NoError(t, err)
+	data[len(data)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(cache.getFilePath("key1"), data, 0o600))
+	require.NoError(t, os.WriteFile(cache.getFilePath("key2"), data[:10], 0o600))
+
+	corrupted, err = Verify(dir)
+	require.NoError(t, err)
+	require.Len(t, corrupted, 2)
+	require.Equal(t, "key1", corrupted[0].Key)
+	require.Equal(t, "checksum mismatch", corrupted[0].Reason)
+	require.Equal(t, "key2", corrupted[1].Key)
+	require.Contains(t, corrupted[1].Reason, "size 10")
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	fillDir(t, dir, "key1", "key2", "key3")
+	listing, err := List(dir)
+	require.NoError(t, err)
+	size := listing.Entries[0].Size
+
+	removed, err := Prune(dir, 2*size)
+	require.NoError(t, err)
+	require.Len(t, removed, 1)
+	require.Equal(t, "key1", removed[0].Key)
+
+	removed, err = Prune(dir, 2*size)
+	require.NoError(t, err)
+	require.Empty(t, removed)
+
+	removed, err = Prune(dir, 0)
+	require.NoError(t, err)
+	require.Len(t, removed, 2)
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestWriteFiles_MetaFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "key1"+imageExt)
+	// a non-empty directory in place of the meta can't be replaced by a rename
+	require.NoError(t, os.MkdirAll(filepath.Join(metaPath(path), "sub"), 0o755))
+
+	_, err := writeFiles(path, []byte("data"), Meta{Key: "key1"})
+	require.Error(t, err)
+	require.NoFileExists(t, path)
+
+	listing, err := List(dir)
+	require.NoError(t, err)
+	require.Empty(t, listing.Entries)
+	// no temporary files are left behind
+	require.Empty(t, listing.Orphans)
+}
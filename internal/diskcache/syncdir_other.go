@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd)
+
+package diskcache
+
+// syncDir is a no-op, directories can't be synced on this platform.
+func syncDir(string) error {
+	return nil
+}
@@ -0,0 +1,192 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/esavich/otus_project/internal/cache"
+	"github.com/esavich/otus_project/internal/metrics"
+)
+
+// ErrCircuitOpen is returned without touching the network while the origin is considered down.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type BreakerState int
+
+const (
+	StateClosed BreakerState = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// breaker is a per host circuit breaker. It opens after threshold consecutive failures,
+// after cooldown lets a single probe request through and closes again if the probe succeeds.
+type breaker struct {
+	host      string
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mutex    sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func (b *breaker) allow() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case StateClosed:
+		return nil
+	case StateOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return fmt.Errorf("%w: %s", ErrCircuitOpen, b.host)
+		}
+		b.setState(StateHalfOpen)
+		b.probing = true
+		return nil
+	case StateHalfOpen:
+		if b.probing {
+			return fmt.Errorf("%w: %s", ErrCircuitOpen, b.host)
+		}
+		b.probing = true
+		return nil
+	}
+
+	return nil
+}
+
+func (b *breaker) record(failed bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.probing = false
+	if !failed {
+		b.failures = 0
+		if b.state != StateClosed {
+			b.setState(StateClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == StateHalfOpen || b.failures >= b.threshold {
+		b.openedAt = b.now()
+		if b.state != StateOpen {
+			b.setState(StateOpen)
+		}
+	}
+}
+
+func (b *breaker) currentState() BreakerState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.state
+}
+
+// setState must be called with the mutex held.
+func (b *breaker) setState(state BreakerState) {
+	slog.Warn("Circuit breaker state changed",
+		slog.String("host", b.host),
+		slog.String("from", b.state.String()),
+		slog.String("to", state.String()),
+	)
+	b.state = state
+	metrics.SetBreakerState(b.host, int(state))
+}
+
+// maxBreakerHosts bounds the number of tracked hosts, hosts come from client requests.
+// Closed breakers of the least recently used hosts are forgotten, open and half-open ones are kept,
+// so a failing origin isn't hit again before its cooldown ends.
+const maxBreakerHosts = 1024
+
+type breakers struct {
+	threshold int
+	cooldown  time.Duration
+
+	// mutex makes get or create atomic
+	mutex sync.Mutex
+	hosts cache.Cache[string, *breaker]
+}
+
+func newBreakers(threshold int, cooldown time.Duration) *breakers {
+	return &breakers{
+		threshold: threshold,
+		cooldown:  cooldown,
+		hosts:     cache.NewLRU[string, *breaker](maxBreakerHosts),
+	}
+}
+
+func (bs *breakers) get(host string) *breaker {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+
+	if b, ok := bs.hosts.Get(host); ok {
+		return b
+	}
+
+	b := &breaker{
+		host:      host,
+		threshold: bs.threshold,
+		cooldown:  bs.cooldown,
+		now:       time.Now,
+	}
+	if bs.hosts.Len() >= maxBreakerHosts && !bs.evictClosed() {
+		// every tracked breaker is open, the new host gets a breaker that isn't kept
+		return b
+	}
+	bs.hosts.Set(host, b, nil)
+
+	return b
+}
+
+// evictClosed forgets the least recently used closed breaker, it returns false if there is none.
+// The caller holds bs.mutex.
+func (bs *breakers) evictClosed() bool {
+	var (
+		victim string
+		found  bool
+	)
+	bs.hosts.Range(func(host string, b *breaker) bool {
+		if b.currentState() == StateClosed {
+			victim, found = host, true
+		}
+		return true
+	})
+	if found {
+		bs.hosts.Remove(victim)
+		metrics.DeleteBreakerState(victim)
+	}
+
+	return found
+}
+
+func (bs *breakers) states() map[string]BreakerState {
+	states := make(map[string]BreakerState, bs.hosts.Len())
+	bs.hosts.Range(func(host string, b *breaker) bool {
+		states[host] = b.currentState()
+		return true
+	})
+
+	return states
+}
@@ -0,0 +1,98 @@
+package downloader
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+	b := &breaker{
+		host:      "example.com",
+		threshold: 2,
+		cooldown:  time.Minute,
+		now:       func() time.Time { return now },
+	}
+
+	t.Run("opens after threshold failures", func(t *testing.T) {
+		require.NoError(t, b.allow())
+		b.record(true)
+		require.Equal(t, StateClosed, b.currentState())
+
+		require.NoError(t, b.allow())
+		b.record(true)
+		require.Equal(t, StateOpen, b.currentState())
+
+		require.ErrorIs(t, b.allow(), ErrCircuitOpen)
+	})
+
+	t.Run("single probe after cooldown", func(t *testing.T) {
+		now = now.Add(2 * time.Minute)
+
+		require.NoError(t, b.allow())
+		require.Equal(t, StateHalfOpen, b.currentState())
+		// only one probe at a time
+		require.ErrorIs(t, b.allow(), ErrCircuitOpen)
+	})
+
+	t.Run("failed probe opens again", func(t *testing.T) {
+		b.record(true)
+		require.Equal(t, StateOpen, b.currentState())
+		require.ErrorIs(t, b.allow(), ErrCircuitOpen)
+	})
+
+	t.Run("successful probe closes", func(t *testing.T) {
+		now = now.Add(2 * time.Minute)
+
+		require.NoError(t, b.allow())
+		b.record(false)
+		require.Equal(t, StateClosed, b.currentState())
+		require.NoError(t, b.allow())
+	})
+}
+
+func TestBreakers_Bounded(t *testing.T) {
+	bs := newBreakers(1, time.Minute)
+	open := bs.get("host-0")
+	open.record(true)
+	first := bs.get("host-1")
+	for i := range maxBreakerHosts + 10 {
+		bs.get("host-" + strconv.Itoa(i))
+	}
+
+	states := bs.states()
+	require.Len(t, states, maxBreakerHosts)
+	// the open breaker is kept although it is the least recently used
+	require.Equal(t, StateOpen, states["host-0"])
+	require.Same(t, open, bs.get("host-0"))
+	require.NotContains(t, states, "host-1")
+	require.NotSame(t, first, bs.get("host-1"))
+}
+
+func TestBreakers_AllOpen(t *testing.T) {
+	bs := newBreakers(1, time.Minute)
+	for i := range maxBreakerHosts {
+		bs.get("host-" + strconv.Itoa(i)).record(true)
+	}
+
+	b := bs.get("new-host")
+	require.NoError(t, b.allow())
+
+	states := bs.states()
+	require.Len(t, states, maxBreakerHosts)
+	require.NotContains(t, states, "new-host")
+	require.Equal(t, StateOpen, states["host-0"])
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	require.Equal(t, 3*time.Second, parseRetryAfter("3", now))
+	require.Equal(t, 10*time.Second, parseRetryAfter(now.Add(10*time.Second).Format(http.TimeFormat), now))
+	require.Equal(t, time.Duration(0), parseRetryAfter("", now))
+	require.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+}
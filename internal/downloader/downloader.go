@@ -9,50 +9,260 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/esavich/otus_project/internal/cachecontrol"
+	"github.com/esavich/otus_project/internal/logger"
+	"github.com/esavich/otus_project/internal/metrics"
 )
 
+var tracer = otel.Tracer("github.com/esavich/otus_project/internal/downloader")
+
 type Downloader struct {
-	c  *http.Client
-	to time.Duration
+	c        *http.Client
+	to       time.Duration
+	retry    RetryPolicy
+	breakers *breakers
+	limiter  *hostLimiter
+
+	// header settings can be changed at runtime
+	mutex   sync.RWMutex
+	headers HeaderPolicy
+	redact  []string
+}
+
+type Option func(*Downloader)
+
+// WithRetry enables retries of idempotent failures.
+func WithRetry(policy RetryPolicy) Option {
+	return func(d *Downloader) {
+		d.retry = policy
+	}
+}
+
+// WithCircuitBreaker enables a per host circuit breaker, which opens after threshold
+// consecutive failures and stays open for cooldown.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(d *Downloader) {
+		if threshold > 0 {
+			d.breakers = newBreakers(threshold, cooldown)
+		}
+	}
+}
+
+// WithTransport replaces the default http transport with a tuned one.
+func WithTransport(opts TransportOptions) Option {
+	return func(d *Downloader) {
+		d.c.Transport = newTransport(opts)
+	}
+}
+
+// WithHostConcurrency limits concurrent requests per origin host, requests over the limit
+// wait for a free slot up to the download timeout.
+func WithHostConcurrency(limit int) Option {
+	return func(d *Downloader) {
+		if limit > 0 {
+			d.limiter = newHostLimiter(limit)
+		}
+	}
 }
 
-func NewDownloader(timeout time.Duration) *Downloader {
-	return &Downloader{
-		c:  &http.Client{},
-		to: timeout,
+// WithHeaderPolicy sets which client headers are forwarded to origins.
+func WithHeaderPolicy(policy HeaderPolicy) Option {
+	return func(d *Downloader) {
+		d.headers = policy
 	}
 }
 
-func (d *Downloader) Download(url string, header http.Header) (image.Image, error) {
-	ctx, cancel := context.WithTimeout(context.TODO(), d.to)
+// WithRedactedHeaders hides values of the given headers in logs,
+// credentials like Authorization and Cookie are always hidden.
+func WithRedactedHeaders(names ...string) Option {
+	return func(d *Downloader) {
+		d.redact = names
+	}
+}
+
+// SetHeaderPolicy replaces forwarded and redacted headers of a running downloader.
+func (d *Downloader) SetHeaderPolicy(policy HeaderPolicy, redact ...string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.headers = policy
+	d.redact = redact
+}
+
+func (d *Downloader) headerSettings() (HeaderPolicy, []string) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.headers, d.redact
+}
+
+func NewDownloader(timeout time.Duration, opts ...Option) *Downloader {
+	d := &Downloader{
+		c:       &http.Client{},
+		to:      timeout,
+		headers: HeaderPolicy{Allow: DefaultForwardHeaders},
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d
+}
+
+func (d *Downloader) Download(ctx context.Context, imgURL string, header http.Header) (image.Image, error) {
+	ctx, span := tracer.Start(ctx, "Downloader.Download")
+	defer span.End()
+	span.SetAttributes(attribute.String("url.full", imgURL))
+
+	img, err := d.downloadWithRetry(ctx, imgURL, header)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return img, err
+}
+
+// downloadWithRetry spends at most the downloader timeout on all attempts and delays between them,
+// every attempt gets what is left.
+func (d *Downloader) downloadWithRetry(ctx context.Context, imgURL string, header http.Header) (image.Image, error) {
+	log := logger.FromContext(ctx)
+	ctx, cancel := context.WithTimeout(ctx, d.to)
 	defer cancel()
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+
+	u, err := url.Parse(imgURL)
+	if err != nil {
+		return nil, fmt.Errorf("cant create request: %w", err)
+	}
+
+	var br *breaker
+	if d.breakers != nil {
+		br = d.breakers.get(u.Host)
+	}
+
+	for attempt := 0; ; attempt++ {
+		img, err := d.attempt(ctx, br, u.Host, imgURL, header)
+		if err == nil {
+			return img, nil
+		}
+
+		delay, retry := d.retry.retryDelay(attempt, err)
+		if !retry {
+			return nil, err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= delay {
+			// no time left for another attempt, the error of the last one is more useful than a timeout
+			return nil, err
+		}
+		log.Warn(fmt.Sprintf("Download failed, retrying in %s: %s", delay, err),
+			slog.String("url", imgURL), slog.Int("attempt", attempt+1))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("cant do request: %w", ctx.Err())
+		}
+	}
+}
+
+func (d *Downloader) attempt(
+	ctx context.Context,
+	br *breaker,
+	host, imgURL string,
+	header http.Header,
+) (image.Image, error) {
+	if d.limiter != nil {
+		release, err := d.limiter.acquire(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	if br != nil {
+		if err := br.allow(); err != nil {
+			return nil, err
+		}
+	}
+
+	img, err := d.download(ctx, imgURL, header)
+	if br != nil {
+		br.record(isOriginDown(err))
+	}
+
+	return img, err
+}
+
+func (d *Downloader) download(ctx context.Context, imgURL string, header http.Header) (image.Image, error) {
+	ctx, span := tracer.Start(ctx, "HTTP GET", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imgURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("cant create request: %w", err)
 	}
 
-	req.Header = header
+	// only allowed client headers go to the origin, the client traceparent is replaced with ours
+	policy, redact := d.headerSettings()
+	req.Header = policy.forwardHeaders(header, req.URL.Hostname())
+	// the id the request is logged with, it is generated when the client didn't send a valid one
+	if id := logger.RequestID(ctx); id != "" && policy.allows(RequestIDHeader, req.URL.Hostname()) {
+		req.Header.Set(RequestIDHeader, id)
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	span.SetAttributes(attribute.String("server.address", req.URL.Host))
 
-	slog.Info(fmt.Sprintf("Downloading: %s  with headers: %+v ", url, req.Header))
+	logger.FromContext(ctx).Info(
+		fmt.Sprintf("Downloading: %s  with headers: %+v ", imgURL, redactHeaders(req.Header, redact)))
+	start := time.Now()
 	resp, err := d.c.Do(req)
 	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
 		return nil, fmt.Errorf("cant do request: %w", err)
 	}
+	defer resp.Body.Close()
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("invalid status: %s", resp.Status)
+		span.SetStatus(codes.Error, resp.Status)
+		return nil, &StatusError{
+			Code:       resp.StatusCode,
+			Status:     resp.Status,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
 	}
+	cachecontrol.Record(ctx, resp.Header.Get("Cache-Control"))
 	body, err := io.ReadAll(resp.Body)
-	defer resp.Body.Close()
 	if err != nil {
 		return nil, fmt.Errorf("cant read response body: %w", err)
 	}
+	metrics.ObserveDownload(req.URL.Host, len(body), time.Since(start))
 
 	jpegImage, err := jpeg.Decode(bytes.NewReader(body))
 	if err != nil {
-		return nil, fmt.Errorf("cant decode jpeg image: %w", err)
+		return nil, fmt.Errorf("%w: %w", ErrDecode, err)
 	}
 
 	return jpegImage, nil
 }
+
+// BreakerStates returns the current circuit breaker state of every known origin host.
+func (d *Downloader) BreakerStates() map[string]BreakerState {
+	if d.breakers == nil {
+		return nil
+	}
+
+	return d.breakers.states()
+}
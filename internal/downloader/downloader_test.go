@@ -1,16 +1,24 @@
 package downloader
 
 import (
+	"context"
 	"image"
 	"image/color"
 	"image/jpeg"
 	"net/http"
 	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+
+	"github.com/esavich/otus_project/internal/cachecontrol"
+	"github.com/esavich/otus_project/internal/logger"
 )
 
 var headers = http.Header{
@@ -18,6 +26,9 @@ var headers = http.Header{
 	"User-Agent":    []string{"Mozilla/5.0"},
 }
 
+// forwards the test headers, by default Authorization is not forwarded.
+var forwardTestHeaders = WithHeaderPolicy(HeaderPolicy{Allow: []string{"Authorization", "User-Agent"}})
+
 func TestDownloader_OK(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		assert.Equal(t, "/image.jpg", r.URL.Path)
@@ -37,10 +48,10 @@ func TestDownloader_OK(t *testing.T) {
 
 	defer server.Close()
 
-	d := NewDownloader(2 * time.Second)
+	d := NewDownloader(2*time.Second, forwardTestHeaders)
 
 	imgURL := server.URL + "/image.jpg"
-	result, err := d.Download(imgURL, headers)
+	result, err := d.Download(context.Background(), imgURL, headers)
 
 	require.NoError(t, err)
 	require.NotNil(t, result)
@@ -59,21 +70,35 @@ func TestDownloader_ErrorCode(t *testing.T) {
 	}))
 	defer server.Close()
 
-	d := NewDownloader(2 * time.Second)
+	d := NewDownloader(2*time.Second, forwardTestHeaders)
 
 	imgURL := server.URL + "/image.jpg"
-	result, err := d.Download(imgURL, headers)
+	result, err := d.Download(context.Background(), imgURL, headers)
 
 	require.Nil(t, result)
 	require.Error(t, err)
 	require.ErrorContains(t, err, "invalid status: 400")
+	require.False(t, IsPermanent(err))
+}
+
+func TestDownloader_NotFound(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	d := NewDownloader(2*time.Second, forwardTestHeaders)
+
+	result, err := d.Download(context.Background(), server.URL+"/image.jpg", headers)
+
+	require.Nil(t, result)
+	require.ErrorContains(t, err, "invalid status: 404")
+	require.True(t, IsPermanent(err))
 }
 
 func TestDownloader_InvalidUrl(t *testing.T) {
-	d := NewDownloader(2 * time.Second)
+	d := NewDownloader(2*time.Second, forwardTestHeaders)
 
 	imgURL := "invalid/image.jpg"
-	result, err := d.Download(imgURL, headers)
+	result, err := d.Download(context.Background(), imgURL, headers)
 
 	require.Nil(t, result)
 	require.Error(t, err)
@@ -93,14 +118,15 @@ func TestDownloader_NotJpeg(t *testing.T) {
 	}))
 	defer server.Close()
 
-	d := NewDownloader(2 * time.Second)
+	d := NewDownloader(2*time.Second, forwardTestHeaders)
 
 	imgURL := server.URL + "/image.jpg"
-	result, err := d.Download(imgURL, headers)
+	result, err := d.Download(context.Background(), imgURL, headers)
 
 	require.Nil(t, result)
 	require.Error(t, err)
 	require.ErrorContains(t, err, "cant decode jpeg image")
+	require.True(t, IsPermanent(err))
 }
 
 func TestDownloader_Timeout(t *testing.T) {
@@ -119,12 +145,293 @@ func TestDownloader_Timeout(t *testing.T) {
 	}))
 	defer server.Close()
 
-	d := NewDownloader(100 * time.Millisecond)
+	d := NewDownloader(100*time.Millisecond, forwardTestHeaders)
 
 	imgURL := server.URL + "/image.jpg"
-	result, err := d.Download(imgURL, headers)
+	result, err := d.Download(context.Background(), imgURL, headers)
 
 	require.Nil(t, result)
 	require.Error(t, err)
 	require.ErrorContains(t, err, "context deadline exceeded")
+	require.False(t, IsPermanent(err))
+}
+
+func TestDownloader_Retry(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+		jpeg.Encode(w, img, nil)
+	}))
+	defer server.Close()
+
+	d := NewDownloader(2*time.Second, WithRetry(RetryPolicy{
+		MaxRetries: 2,
+		BaseDelay:  time.Millisecond,
+		MaxDelay:   10 * time.Millisecond,
+	}))
+
+	result, err := d.Download(context.Background(), server.URL+"/image.jpg", headers)
+
+	require.NoError(t, err)
+	require.NotNil(t, result)
+	require.Equal(t, int32(3), calls.Load())
+}
+
+func TestDownloader_RetryExhausted(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	d := NewDownloader(2*time.Second, WithRetry(RetryPolicy{
+		MaxRetries: 2,
+		BaseDelay:  time.Millisecond,
+		MaxDelay:   10 * time.Millisecond,
+	}))
+
+	_, err := d.Download(context.Background(), server.URL+"/image.jpg", headers)
+
+	require.ErrorContains(t, err, "invalid status: 502")
+	require.Equal(t, int32(3), calls.Load())
+}
+
+func TestDownloader_RetryWithinTimeout(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		time.Sleep(60 * time.Millisecond)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	d := NewDownloader(100*time.Millisecond, WithRetry(RetryPolicy{
+		MaxRetries: 5,
+		BaseDelay:  time.Millisecond,
+		MaxDelay:   time.Millisecond,
+	}))
+
+	// the timeout covers all attempts, not each of them
+	start := time.Now()
+	_, err := d.Download(context.Background(), server.URL+"/image.jpg", headers)
+
+	require.Error(t, err)
+	require.Less(t, time.Since(start), 300*time.Millisecond)
+	require.Equal(t, int32(2), calls.Load())
+}
+
+func TestDownloader_RetryAfter(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	d := NewDownloader(2*time.Second, WithRetry(RetryPolicy{
+		MaxRetries: 2,
+		BaseDelay:  time.Millisecond,
+		MaxDelay:   10 * time.Millisecond,
+	}))
+
+	// origin asks to wait longer than allowed, so no retry
+	_, err := d.Download(context.Background(), server.URL+"/image.jpg", headers)
+
+	require.ErrorContains(t, err, "invalid status: 429")
+	require.Equal(t, int32(1), calls.Load())
+}
+
+func TestDownloader_NoRetryOnNotFound(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	d := NewDownloader(2*time.Second, WithRetry(RetryPolicy{
+		MaxRetries: 2,
+		BaseDelay:  time.Millisecond,
+		MaxDelay:   10 * time.Millisecond,
+	}))
+
+	_, err := d.Download(context.Background(), server.URL+"/image.jpg", headers)
+
+	require.ErrorContains(t, err, "invalid status: 404")
+	require.Equal(t, int32(1), calls.Load())
+}
+
+func TestDownloader_CircuitBreaker(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	d := NewDownloader(2*time.Second, WithCircuitBreaker(2, time.Minute))
+
+	for i := 0; i < 2; i++ {
+		_, err := d.Download(context.Background(), server.URL+"/image.jpg", headers)
+		require.ErrorContains(t, err, "invalid status: 500")
+	}
+
+	// origin is considered down, fail fast
+	_, err := d.Download(context.Background(), server.URL+"/image.jpg", headers)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.Equal(t, int32(2), calls.Load())
+
+	for _, state := range d.BreakerStates() {
+		require.Equal(t, StateOpen, state)
+	}
+}
+
+func TestDownloader_HostConcurrency(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			prev := maxInFlight.Load()
+			if current <= prev || maxInFlight.CompareAndSwap(prev, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+
+		img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+		jpeg.Encode(w, img, nil)
+	}))
+	defer server.Close()
+
+	d := NewDownloader(2*time.Second, WithHostConcurrency(2), WithTransport(TransportOptions{
+		MaxIdleConnsPerHost: 2,
+		DialTimeout:         time.Second,
+	}))
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := d.Download(context.Background(), server.URL+"/image.jpg", headers)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	require.LessOrEqual(t, maxInFlight.Load(), int32(2))
+}
+
+func TestHostLimiter_Busy(t *testing.T) {
+	hl := newHostLimiter(1)
+
+	release, err := hl.acquire(context.Background(), "example.com")
+	require.NoError(t, err)
+
+	// the only slot is taken
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = hl.acquire(ctx, "example.com")
+	require.ErrorIs(t, err, ErrHostBusy)
+
+	// other hosts are not affected
+	releaseOther, err := hl.acquire(ctx, "example.org")
+	require.NoError(t, err)
+	releaseOther()
+
+	release()
+	release, err = hl.acquire(context.Background(), "example.com")
+	require.NoError(t, err)
+	release()
+
+	// idle hosts are forgotten
+	require.Empty(t, hl.hosts)
+}
+
+func TestDownloader_RequestID(t *testing.T) {
+	received := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get(RequestIDHeader)
+		jpeg.Encode(w, image.NewRGBA(image.Rect(0, 0, 1, 1)), nil)
+	}))
+	defer server.Close()
+
+	// the id of the request, not the client header which may have been replaced
+	ctx := logger.WithRequestID(context.Background(), "generated-id")
+	client := http.Header{RequestIDHeader: []string{"invalid id"}}
+
+	_, err := NewDownloader(2*time.Second).Download(ctx, server.URL+"/image.jpg", client)
+	require.NoError(t, err)
+	require.Equal(t, "generated-id", <-received)
+
+	_, err = NewDownloader(2*time.Second, forwardTestHeaders).Download(ctx, server.URL+"/image.jpg", client)
+	require.NoError(t, err)
+	require.Empty(t, <-received)
+}
+
+func TestDownloader_PropagatesTraceContext(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	incoming := http.Header{"Traceparent": []string{traceparent}}
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(incoming))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// same trace, the parent is the span of the downloader
+		assert.Contains(t, r.Header.Get("Traceparent"), "4bf92f3577b34da6a3ce929d0e0e4736")
+
+		img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+		jpeg.Encode(w, img, nil)
+	}))
+	defer server.Close()
+
+	d := NewDownloader(2*time.Second, forwardTestHeaders)
+
+	_, err := d.Download(ctx, server.URL+"/image.jpg", incoming)
+	require.NoError(t, err)
+	// incoming headers are not modified
+	require.Equal(t, traceparent, incoming.Get("Traceparent"))
+}
+
+func TestDownloader_RecordsCacheControl(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Cache-Control", "private, max-age=60")
+		jpeg.Encode(w, image.NewRGBA(image.Rect(0, 0, 1, 1)), nil)
+	}))
+	defer server.Close()
+
+	d := NewDownloader(2 * time.Second)
+	ctx, rec := cachecontrol.WithRecorder(context.Background())
+	_, err := d.Download(ctx, server.URL+"/image.jpg", http.Header{})
+	require.NoError(t, err)
+
+	require.True(t, rec.Directives().Private)
+	require.False(t, rec.Directives().Shareable())
+}
+
+func TestDownloader_SetHeaderPolicy(t *testing.T) {
+	var auth atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth.Store(r.Header.Get("Authorization"))
+		jpeg.Encode(w, image.NewRGBA(image.Rect(0, 0, 1, 1)), nil)
+	}))
+	defer server.Close()
+
+	d := NewDownloader(2 * time.Second)
+	_, err := d.Download(context.Background(), server.URL+"/image.jpg", headers)
+	require.NoError(t, err)
+	require.Empty(t, auth.Load())
+
+	d.SetHeaderPolicy(HeaderPolicy{Allow: []string{"Authorization"}})
+	_, err = d.Download(context.Background(), server.URL+"/image.jpg", headers)
+	require.NoError(t, err)
+	require.Equal(t, headers.Get("Authorization"), auth.Load())
 }
@@ -0,0 +1,36 @@
+package downloader
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ErrDecode is returned when the origin response is not a valid jpeg image.
+var ErrDecode = errors.New("cant decode jpeg image")
+
+// StatusError is returned when the origin responds with a non-200 status.
+type StatusError struct {
+	Code       int
+	Status     string
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return "invalid status: " + e.Status
+}
+
+// IsPermanent reports whether err is an upstream failure that will not go away
+// by itself, so it is safe to remember it for a while.
+func IsPermanent(err error) bool {
+	if errors.Is(err, ErrDecode) {
+		return true
+	}
+
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Code == http.StatusNotFound || se.Code == http.StatusGone
+	}
+
+	return false
+}
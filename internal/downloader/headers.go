@@ -0,0 +1,96 @@
+package downloader
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// DefaultForwardHeaders are forwarded to origins when no policy is configured, the same as the config default.
+var DefaultForwardHeaders = []string{"Accept", "Accept-Language", "User-Agent", RequestIDHeader}
+
+// RequestIDHeader carries the id of the request from the context, when the policy forwards it.
+const RequestIDHeader = "X-Request-ID"
+
+// hopByHopHeaders are meaningful only for a single connection and never forwarded,
+// Host is set from the origin url.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+	"Host",
+}
+
+// sensitiveHeaders are always redacted in logs.
+var sensitiveHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	"X-Auth-Token",
+}
+
+const redacted = "[REDACTED]"
+
+// HeaderPolicy decides which client headers are forwarded to origins.
+// Headers from ByHost are forwarded to the matching host name in addition to Allow.
+type HeaderPolicy struct {
+	Allow  []string
+	ByHost map[string][]string
+}
+
+// allows reports whether the header is forwarded to the origin host.
+func (p HeaderPolicy) allows(name, host string) bool {
+	match := func(allowed string) bool { return strings.EqualFold(allowed, name) }
+	return slices.ContainsFunc(p.Allow, match) || slices.ContainsFunc(p.ByHost[strings.ToLower(host)], match)
+}
+
+// forwardHeaders copies allowed headers for the origin host, dropping hop-by-hop ones.
+func (p HeaderPolicy) forwardHeaders(header http.Header, host string) http.Header {
+	forwarded := http.Header{}
+	copyHeaders := func(names []string) {
+		for _, name := range names {
+			if values := header.Values(name); len(values) > 0 {
+				forwarded[http.CanonicalHeaderKey(name)] = append([]string(nil), values...)
+			}
+		}
+	}
+	copyHeaders(p.Allow)
+	copyHeaders(p.ByHost[strings.ToLower(host)])
+
+	// headers listed in Connection are hop-by-hop too
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			forwarded.Del(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		forwarded.Del(name)
+	}
+
+	return forwarded
+}
+
+// redactHeaders returns a copy of header safe to write to logs.
+func redactHeaders(header http.Header, extra []string) http.Header {
+	safe := header.Clone()
+	if safe == nil {
+		return http.Header{}
+	}
+	for _, names := range [][]string{sensitiveHeaders, extra} {
+		for _, name := range names {
+			if _, ok := safe[http.CanonicalHeaderKey(name)]; ok {
+				safe.Set(name, redacted)
+			}
+		}
+	}
+
+	return safe
+}
@@ -0,0 +1,72 @@
+package downloader
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeaderPolicy_ForwardHeaders(t *testing.T) {
+	client := http.Header{
+		"Accept":        []string{"image/jpeg"},
+		"Authorization": []string{"Bearer secret"},
+		"Cookie":        []string{"session=secret"},
+		"Connection":    []string{"keep-alive, X-Custom"},
+		"X-Custom":      []string{"value"},
+		"Host":          []string{"evil.example.com"},
+		"Upgrade":       []string{"h2c"},
+	}
+
+	t.Run("default", func(t *testing.T) {
+		forwarded := HeaderPolicy{Allow: DefaultForwardHeaders}.forwardHeaders(client, "example.com")
+
+		require.Equal(t, http.Header{"Accept": []string{"image/jpeg"}}, forwarded)
+	})
+
+	t.Run("per host", func(t *testing.T) {
+		policy := HeaderPolicy{
+			Allow:  []string{"Accept"},
+			ByHost: map[string][]string{"private.example.com": {"Authorization", "Cookie"}},
+		}
+
+		forwarded := policy.forwardHeaders(client, "private.example.com")
+		require.Equal(t, "Bearer secret", forwarded.Get("Authorization"))
+		require.Equal(t, "session=secret", forwarded.Get("Cookie"))
+		require.Equal(t, "image/jpeg", forwarded.Get("Accept"))
+
+		forwarded = policy.forwardHeaders(client, "other.example.com")
+		require.Empty(t, forwarded.Get("Authorization"))
+		require.Empty(t, forwarded.Get("Cookie"))
+	})
+
+	t.Run("hop-by-hop never forwarded", func(t *testing.T) {
+		policy := HeaderPolicy{Allow: []string{"Connection", "Upgrade", "Host", "X-Custom", "Accept"}}
+
+		forwarded := policy.forwardHeaders(client, "example.com")
+		require.Equal(t, http.Header{"Accept": []string{"image/jpeg"}}, forwarded)
+	})
+
+	t.Run("client headers not modified", func(t *testing.T) {
+		HeaderPolicy{Allow: []string{"Accept"}}.forwardHeaders(client, "example.com")
+		require.Equal(t, "value", client.Get("X-Custom"))
+	})
+}
+
+func TestRedactHeaders(t *testing.T) {
+	header := http.Header{
+		"Authorization": []string{"Bearer secret"},
+		"Cookie":        []string{"a=1", "b=2"},
+		"X-Signature":   []string{"secret"},
+		"Accept":        []string{"image/jpeg"},
+	}
+
+	safe := redactHeaders(header, []string{"X-Signature"})
+
+	require.Equal(t, []string{redacted}, safe.Values("Authorization"))
+	require.Equal(t, []string{redacted}, safe.Values("Cookie"))
+	require.Equal(t, []string{redacted}, safe.Values("X-Signature"))
+	require.Equal(t, "image/jpeg", safe.Get("Accept"))
+	require.Equal(t, "Bearer secret", header.Get("Authorization"), "original must not be changed")
+	require.Empty(t, redactHeaders(nil, nil))
+}
@@ -0,0 +1,110 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy describes how failed downloads are retried.
+// Delays grow exponentially from BaseDelay up to MaxDelay with full jitter.
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+// backoff returns the delay before the retry number attempt (starting from 0).
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	limit := p.MaxDelay
+	if shift := p.BaseDelay << attempt; shift > 0 && shift < limit {
+		limit = shift
+	}
+	if limit <= 0 {
+		return 0
+	}
+
+	return rand.N(limit) + 1 //nolint:gosec
+}
+
+// retryDelay decides if the failed attempt must be retried and how long to wait before it.
+func (p RetryPolicy) retryDelay(attempt int, err error) (time.Duration, bool) {
+	if attempt >= p.MaxRetries {
+		return 0, false
+	}
+
+	var se *StatusError
+	if errors.As(err, &se) {
+		switch se.Code {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return p.backoff(attempt), true
+		case http.StatusTooManyRequests:
+			if se.RetryAfter == 0 {
+				return p.backoff(attempt), true
+			}
+			// do not wait longer than allowed, the origin asked for more than we can give
+			if se.RetryAfter > p.MaxDelay {
+				return 0, false
+			}
+			return se.RetryAfter, true
+		default:
+			return 0, false
+		}
+	}
+
+	if isConnectionError(err) {
+		return p.backoff(attempt), true
+	}
+
+	return 0, false
+}
+
+// isConnectionError reports whether the request failed on the network level,
+// timeouts are excluded because the whole attempt budget is already spent.
+func isConnectionError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	// unknown host will not appear on retry
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// isOriginDown reports whether the error means the origin is unavailable, used by the circuit breaker.
+func isOriginDown(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Code >= http.StatusInternalServerError
+	}
+
+	return isConnectionError(err) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// parseRetryAfter parses the Retry-After header, which is either seconds or a http date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+
+	return 0
+}
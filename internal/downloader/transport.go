@@ -0,0 +1,128 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrHostBusy is returned when no connection slot to the origin host was freed in time.
+var ErrHostBusy = errors.New("too many concurrent requests to host")
+
+// same as in http.DefaultTransport.
+const (
+	defaultDialTimeout = 30 * time.Second
+	defaultKeepAlive   = 30 * time.Second
+)
+
+// TransportOptions tunes the connection pool used for origin requests, zero values keep http defaults.
+type TransportOptions struct {
+	MaxConnsPerHost       int
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+	IdleConnTimeout       time.Duration
+	KeepAlive             time.Duration
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+}
+
+func newTransport(opts TransportOptions) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+
+	dialer := &net.Dialer{
+		Timeout:   defaultDialTimeout,
+		KeepAlive: defaultKeepAlive,
+	}
+	if opts.DialTimeout > 0 {
+		dialer.Timeout = opts.DialTimeout
+	}
+	if opts.KeepAlive != 0 {
+		// negative value disables keep-alive probes
+		dialer.KeepAlive = opts.KeepAlive
+	}
+	t.DialContext = dialer.DialContext
+	t.MaxConnsPerHost = opts.MaxConnsPerHost
+	if opts.MaxIdleConns > 0 {
+		t.MaxIdleConns = opts.MaxIdleConns
+	}
+	if opts.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+	}
+	if opts.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = opts.IdleConnTimeout
+	}
+	if opts.TLSHandshakeTimeout > 0 {
+		t.TLSHandshakeTimeout = opts.TLSHandshakeTimeout
+	}
+	t.ResponseHeaderTimeout = opts.ResponseHeaderTimeout
+
+	return t
+}
+
+// hostLimiter limits the number of concurrent requests per origin host,
+// excess requests wait in a queue until a slot is free.
+type hostLimiter struct {
+	limit int
+
+	mutex sync.Mutex
+	hosts map[string]*hostSlots
+}
+
+// hostSlots are slots of a host, they are dropped once no request holds or waits for one,
+// so hosts from client requests don't pile up.
+type hostSlots struct {
+	slots chan struct{}
+	users int
+}
+
+func newHostLimiter(limit int) *hostLimiter {
+	return &hostLimiter{
+		limit: limit,
+		hosts: make(map[string]*hostSlots),
+	}
+}
+
+func (hl *hostLimiter) acquire(ctx context.Context, host string) (func(), error) {
+	hl.mutex.Lock()
+	hs, ok := hl.hosts[host]
+	if !ok {
+		hs = &hostSlots{slots: make(chan struct{}, hl.limit)}
+		hl.hosts[host] = hs
+	}
+	hs.users++
+	hl.mutex.Unlock()
+
+	release := func() {
+		<-hs.slots
+		hl.leave(host, hs)
+	}
+	// prefer a free slot even if the context is already done
+	select {
+	case hs.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	select {
+	case hs.slots <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		hl.leave(host, hs)
+		return nil, fmt.Errorf("%w: %s", ErrHostBusy, host)
+	}
+}
+
+func (hl *hostLimiter) leave(host string, hs *hostSlots) {
+	hl.mutex.Lock()
+	defer hl.mutex.Unlock()
+
+	hs.users--
+	if hs.users == 0 {
+		delete(hl.hosts, host)
+	}
+}
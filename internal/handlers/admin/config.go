@@ -0,0 +1,21 @@
+package admin
+
+import (
+	"net/http"
+)
+
+type ConfigHandler struct {
+	current func() any
+}
+
+// NewConfigHandler serves the config returned by current as is, secrets must be redacted by the caller.
+func NewConfigHandler(current func() any) *ConfigHandler {
+	return &ConfigHandler{
+		current: current,
+	}
+}
+
+// Config reports the running configuration.
+func (h *ConfigHandler) Config(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, h.current())
+}
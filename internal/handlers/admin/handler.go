@@ -0,0 +1,159 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/esavich/otus_project/internal/diskcache"
+	"github.com/esavich/otus_project/internal/logger"
+)
+
+const (
+	defaultEntriesLimit = 100
+	maxEntriesLimit     = 10000
+)
+
+type Cache interface {
+	Stats() diskcache.Stats
+	Entries(filter func(diskcache.Entry) bool, limit int) []diskcache.Entry
+	Remove(ctx context.Context, key string) bool
+	PurgeSource(ctx context.Context, source string) int
+	Purge(ctx context.Context, filter func(diskcache.Entry) bool) int
+}
+
+// Failures remembers upstream failures by source url, see service.NegativeCachedImageService.
+type Failures interface {
+	Forget(match func(source string) bool) int
+}
+
+type purgeResult struct {
+	Purged int `json:"purged"`
+	// remembered failures dropped with the entries, so the source is downloaded again
+	Failures int `json:"failures"`
+}
+
+type Handler struct {
+	cache    Cache
+	failures Failures
+}
+
+// NewAdminHandler creates the handler, failures may be nil without a negative cache.
+func NewAdminHandler(cache Cache, failures Failures) *Handler {
+	return &Handler{
+		cache:    cache,
+		failures: failures,
+	}
+}
+
+// Stats reports cache counters.
+func (h *Handler) Stats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, h.cache.Stats())
+}
+
+// Entries lists cached entries, most recently used first.
+// Supports ?host= and ?prefix= filters by source url and ?limit=.
+func (h *Handler) Entries(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := defaultEntriesLimit
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit parameter: "+value, http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxEntriesLimit)
+	}
+
+	var filter func(diskcache.Entry) bool
+	switch {
+	case query.Has("host"):
+		filter = hostFilter(query.Get("host"))
+	case query.Has("prefix"):
+		filter = prefixFilter(query.Get("prefix"))
+	}
+
+	entries := h.cache.Entries(filter, limit)
+	if entries == nil {
+		entries = []diskcache.Entry{}
+	}
+	writeJSON(w, r, http.StatusOK, entries)
+}
+
+// Purge deletes cached entries by exactly one of ?key=, ?url= (all variants of the source),
+// ?host= or ?prefix= of the source url. Remembered failures of the matching sources are dropped too,
+// except for ?key=, which names a single variant.
+func (h *Handler) Purge(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	var given []string
+	for _, param := range []string{"key", "url", "host", "prefix"} {
+		if query.Get(param) != "" {
+			given = append(given, param)
+		}
+	}
+	if len(given) != 1 {
+		http.Error(w, "Exactly one of key, url, host or prefix parameters is required", http.StatusBadRequest)
+		return
+	}
+
+	value := query.Get(given[0])
+	var (
+		result purgeResult
+		filter func(diskcache.Entry) bool
+	)
+	switch given[0] {
+	case "key":
+		if h.cache.Remove(ctx, value) {
+			result.Purged = 1
+		}
+	case "url":
+		result.Purged = h.cache.PurgeSource(ctx, value)
+		filter = func(e diskcache.Entry) bool { return e.Source == value }
+	case "host":
+		filter = hostFilter(value)
+		result.Purged = h.cache.Purge(ctx, filter)
+	case "prefix":
+		filter = prefixFilter(value)
+		result.Purged = h.cache.Purge(ctx, filter)
+	}
+	if filter != nil && h.failures != nil {
+		result.Failures = h.failures.Forget(func(source string) bool {
+			return filter(diskcache.Entry{Source: source})
+		})
+	}
+
+	logger.FromContext(ctx).Info("Cache purged", slog.String(given[0], value),
+		slog.Int("purged", result.Purged), slog.Int("failures", result.Failures))
+	writeJSON(w, r, http.StatusOK, result)
+}
+
+func hostFilter(host string) func(diskcache.Entry) bool {
+	return func(e diskcache.Entry) bool {
+		u, err := url.Parse(e.Source)
+		if err != nil {
+			return false
+		}
+		return strings.EqualFold(u.Host, host) || strings.EqualFold(u.Hostname(), host)
+	}
+}
+
+func prefixFilter(prefix string) func(diskcache.Entry) bool {
+	return func(e diskcache.Entry) bool {
+		return strings.HasPrefix(e.Source, prefix)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		logger.FromContext(r.Context()).Error(err.Error())
+	}
+}
@@ -0,0 +1,233 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/esavich/otus_project/internal/diskcache"
+	"github.com/esavich/otus_project/internal/ratelimit"
+)
+
+func newTestCache(t *testing.T) *diskcache.Wrapper {
+	t.Helper()
+
+	dc, err := diskcache.NewDiskCacheWrapper(10, t.TempDir())
+	require.NoError(t, err)
+
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	for key, source := range map[string]string{
+		"100-100-a": "http://a.example.com/a.jpg",
+		"200-200-a": "http://a.example.com/a.jpg",
+		"100-100-b": "http://a.example.com/img/b.jpg",
+		"100-100-c": "http://c.example.com:8080/c.jpg",
+	} {
+		require.NoError(t, dc.Set(context.Background(), key, source, img))
+	}
+
+	return dc
+}
+
+func TestHandler_Entries(t *testing.T) {
+	h := NewAdminHandler(newTestCache(t), nil)
+
+	tests := []struct {
+		query    string
+		status   int
+		expected int
+	}{
+		{query: "", status: http.StatusOK, expected: 4},
+		{query: "?limit=2", status: http.StatusOK, expected: 2},
+		{query: "?host=a.example.com", status: http.StatusOK, expected: 3},
+		{query: "?host=c.example.com", status: http.StatusOK, expected: 1},
+		{query: "?prefix=http://a.example.com/img/", status: http.StatusOK, expected: 1},
+		{query: "?host=unknown", status: http.StatusOK, expected: 0},
+		{query: "?limit=x", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.Entries(rec, httptest.NewRequest(http.MethodGet, "/admin/cache/entries"+tt.query, nil))
+			require.Equal(t, tt.status, rec.Code)
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var entries []diskcache.Entry
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&entries))
+			require.Len(t, entries, tt.expected)
+		})
+	}
+}
+
+// fakeFailures remembers failures of sources.
+type fakeFailures map[string]bool
+
+func (f fakeFailures) Forget(match func(source string) bool) int {
+	n := 0
+	for source := range f {
+		if match(source) {
+			delete(f, source)
+			n++
+		}
+	}
+	return n
+}
+
+func TestHandler_Purge(t *testing.T) {
+	dc := newTestCache(t)
+	failures := fakeFailures{
+		"http://a.example.com/a.jpg":       true,
+		"http://a.example.com/missing.jpg": true,
+		"http://c.example.com:8080/d.jpg":  true,
+	}
+	h := NewAdminHandler(dc, failures)
+
+	forgotten := 0
+	purge := func(query string) (int, int) {
+		rec := httptest.NewRecorder()
+		h.Purge(rec, httptest.NewRequest(http.MethodDelete, "/admin/cache"+query, nil))
+		var res purgeResult
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+		}
+		forgotten += res.Failures
+		return rec.Code, res.Purged
+	}
+
+	code, _ := purge("")
+	require.Equal(t, http.StatusBadRequest, code)
+	code, _ = purge("?key=a&host=b")
+	require.Equal(t, http.StatusBadRequest, code)
+
+	code, purged := purge("?url=http://a.example.com/a.jpg")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, 2, purged)
+
+	_, purged = purge("?key=100-100-b")
+	require.Equal(t, 1, purged)
+	_, purged = purge("?key=100-100-b")
+	require.Equal(t, 0, purged)
+
+	_, purged = purge("?host=c.example.com")
+	require.Equal(t, 1, purged)
+	require.Equal(t, int64(0), dc.Stats().Items)
+
+	// failures of the purged url and host are forgotten, ?key= keeps them
+	require.Equal(t, 2, forgotten)
+	require.Equal(t, fakeFailures{"http://a.example.com/missing.jpg": true}, failures)
+}
+
+func TestHandler_Stats(t *testing.T) {
+	h := NewAdminHandler(newTestCache(t), nil)
+
+	rec := httptest.NewRecorder()
+	h.Stats(rec, httptest.NewRequest(http.MethodGet, "/admin/cache/stats", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var stats diskcache.Stats
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&stats))
+	require.Equal(t, int64(4), stats.Items)
+}
+
+type fakeGetter struct{}
+
+func (fakeGetter) GetResizedImage(ctx context.Context, w, h int, _ string, _ http.Header) (image.Image, error) {
+	if !ratelimit.Exempt(ctx) {
+		return nil, errors.New("warm-up must not be rate limited")
+	}
+	return image.NewRGBA(image.Rect(0, 0, w, h)), nil
+}
+
+func TestWarmupHandler_Warmup(t *testing.T) {
+	h := NewWarmupHandler(fakeGetter{}, 2)
+
+	body := `{"urls":["http://example.com/a.jpg"],"sizes":["10x10","20x20"],"concurrency":10}`
+	rec := httptest.NewRecorder()
+	h.Warmup(rec, httptest.NewRequest(http.MethodPost, "/admin/cache/warmup", strings.NewReader(body)))
+	require.Equal(t, http.StatusAccepted, rec.Code)
+
+	var job warmupJob
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&job))
+	require.Equal(t, jobRunning, job.Status)
+	require.Equal(t, 2, job.Total)
+	require.Equal(t, "/admin/cache/warmup/"+job.ID, rec.Header().Get("Location"))
+
+	require.Eventually(t, func() bool {
+		job = warmupStatus(t, h, job.ID)
+		return job.Status == jobDone
+	}, time.Second, 5*time.Millisecond)
+	require.Equal(t, 2, job.Report.Total)
+	require.Equal(t, 2, job.Report.Succeeded)
+
+	for _, body := range []string{"not json", `{"urls":["http://example.com/a.jpg"]}`} {
+		rec := httptest.NewRecorder()
+		h.Warmup(rec, httptest.NewRequest(http.MethodPost, "/admin/cache/warmup", strings.NewReader(body)))
+		require.Equal(t, http.StatusBadRequest, rec.Code, body)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/admin/cache/warmup/unknown", nil)
+	r.SetPathValue("id", "unknown")
+	rec = httptest.NewRecorder()
+	h.Status(rec, r)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+// blockingGetter resizes once release is closed.
+type blockingGetter struct {
+	release chan struct{}
+}
+
+func (g blockingGetter) GetResizedImage(_ context.Context, w, h int, _ string, _ http.Header) (image.Image, error) {
+	<-g.release
+	return image.NewRGBA(image.Rect(0, 0, w, h)), nil
+}
+
+func TestWarmupHandler_OneAtATime(t *testing.T) {
+	getter := blockingGetter{release: make(chan struct{})}
+	h := NewWarmupHandler(getter, 1)
+	body := `{"urls":["http://example.com/a.jpg"],"sizes":["10x10"]}`
+
+	rec := httptest.NewRecorder()
+	h.Warmup(rec, httptest.NewRequest(http.MethodPost, "/admin/cache/warmup", strings.NewReader(body)))
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	var job warmupJob
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&job))
+
+	rec = httptest.NewRecorder()
+	h.Warmup(rec, httptest.NewRequest(http.MethodPost, "/admin/cache/warmup", strings.NewReader(body)))
+	require.Equal(t, http.StatusConflict, rec.Code)
+	require.Contains(t, rec.Body.String(), job.ID)
+
+	close(getter.release)
+	require.Eventually(t, func() bool {
+		return warmupStatus(t, h, job.ID).Status == jobDone
+	}, time.Second, 5*time.Millisecond)
+
+	rec = httptest.NewRecorder()
+	h.Warmup(rec, httptest.NewRequest(http.MethodPost, "/admin/cache/warmup", strings.NewReader(body)))
+	require.Equal(t, http.StatusAccepted, rec.Code)
+}
+
+func warmupStatus(t *testing.T, h *WarmupHandler, id string) warmupJob {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, "/admin/cache/warmup/"+id, nil)
+	r.SetPathValue("id", id)
+	rec := httptest.NewRecorder()
+	h.Status(rec, r)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var job warmupJob
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&job))
+
+	return job
+}
@@ -0,0 +1,123 @@
+package admin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/esavich/otus_project/internal/cache"
+	"github.com/esavich/otus_project/internal/logger"
+	"github.com/esavich/otus_project/internal/ratelimit"
+	"github.com/esavich/otus_project/internal/warmup"
+)
+
+const (
+	// maxWarmupBody limits the json body of a warm-up request.
+	maxWarmupBody = 1 << 20
+	// maxWarmupJobs is the number of finished warm-ups whose reports are kept.
+	maxWarmupJobs = 16
+)
+
+const (
+	jobRunning = "running"
+	jobDone    = "done"
+)
+
+type warmupJob struct {
+	ID      string         `json:"id"`
+	Status  string         `json:"status"`
+	Total   int            `json:"total"`
+	Started time.Time      `json:"started"`
+	Report  *warmup.Report `json:"report,omitempty"`
+}
+
+type WarmupHandler struct {
+	getter         warmup.ImageGetter
+	maxConcurrency int
+
+	// mutex guards jobs and running
+	mutex   sync.Mutex
+	jobs    cache.Cache[string, warmupJob]
+	running string
+}
+
+// NewWarmupHandler warms the cache through getter, requests may lower but not raise maxConcurrency.
+func NewWarmupHandler(getter warmup.ImageGetter, maxConcurrency int) *WarmupHandler {
+	return &WarmupHandler{
+		getter:         getter,
+		maxConcurrency: maxConcurrency,
+		jobs:           cache.NewLRU[string, warmupJob](maxWarmupJobs),
+	}
+}
+
+// Warmup starts resizing every url of the json body to every size in the background
+// and answers 202 with the job, its report is served by Status once it is done.
+// Only one warm-up runs at a time, another one gets 409.
+// Cache miss limits don't apply, the concurrency limits the load instead.
+func (h *WarmupHandler) Warmup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req warmup.Request
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWarmupBody)).Decode(&req); err != nil {
+		http.Error(w, "Invalid json body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	items, err := req.Items()
+	if err != nil {
+		http.Error(w, "Invalid warm-up request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	concurrency := h.maxConcurrency
+	if req.Concurrency > 0 {
+		concurrency = min(req.Concurrency, h.maxConcurrency)
+	}
+
+	job := warmupJob{ID: rand.Text(), Status: jobRunning, Total: len(items), Started: time.Now().UTC()}
+	h.mutex.Lock()
+	if h.running != "" {
+		running := h.running
+		h.mutex.Unlock()
+		http.Error(w, "Another warm-up is running: "+running, http.StatusConflict)
+		return
+	}
+	h.running = job.ID
+	h.jobs.Set(job.ID, job, nil)
+	h.mutex.Unlock()
+
+	// the job outlives the request, but keeps its logger and request id
+	go h.run(context.WithoutCancel(ctx), job, items, concurrency)
+
+	w.Header().Set("Location", "/admin/cache/warmup/"+job.ID)
+	writeJSON(w, r, http.StatusAccepted, job)
+}
+
+func (h *WarmupHandler) run(ctx context.Context, job warmupJob, items []warmup.Item, concurrency int) {
+	report := warmup.Run(ratelimit.WithoutLimits(ctx), h.getter, items, concurrency)
+	logger.FromContext(ctx).Info("Cache warmed up", slog.String("job", job.ID),
+		slog.Int("succeeded", report.Succeeded), slog.Int("failed", report.Failed))
+
+	job.Status = jobDone
+	job.Report = &report
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.jobs.Set(job.ID, job, nil)
+	h.running = ""
+}
+
+// Status reports a warm-up started by Warmup, the report of every image is included once it is done.
+// Reports of the last maxWarmupJobs warm-ups are kept.
+func (h *WarmupHandler) Status(w http.ResponseWriter, r *http.Request) {
+	h.mutex.Lock()
+	job, ok := h.jobs.Get(r.PathValue("id"))
+	h.mutex.Unlock()
+	if !ok {
+		http.Error(w, "Unknown warm-up", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, job)
+}
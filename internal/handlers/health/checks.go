@@ -0,0 +1,56 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// errNotSupported is returned by freeSpace on platforms without statfs.
+var errNotSupported = errors.New("not supported on this platform")
+
+// DirWritable checks that a file can be created in dir.
+func DirWritable(dir string) CheckFunc {
+	return func(context.Context) error {
+		f, err := os.CreateTemp(dir, ".readyz-*")
+		if err != nil {
+			return fmt.Errorf("cache dir is not writable: %w", err)
+		}
+		name := f.Name()
+		_, err = f.Write([]byte("ok"))
+		closeErr := f.Close()
+		removeErr := os.Remove(name)
+
+		return errors.Join(err, closeErr, removeErr)
+	}
+}
+
+// FreeSpace checks that the filesystem of dir has at least minFree bytes available.
+func FreeSpace(dir string, minFree uint64) CheckFunc {
+	return func(context.Context) error {
+		free, err := freeSpace(dir)
+		if errors.Is(err, errNotSupported) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("can't get free disk space: %w", err)
+		}
+		if free < minFree {
+			return fmt.Errorf("not enough disk space: %d bytes free, %d required", free, minFree)
+		}
+
+		return nil
+	}
+}
+
+// NotDraining fails while the server is shutting down, so no new traffic is routed to it.
+func NotDraining(draining func() bool) CheckFunc {
+	return func(context.Context) error {
+		if draining() {
+			return errors.New("server is shutting down")
+		}
+
+		return nil
+	}
+}
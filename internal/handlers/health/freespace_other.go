@@ -0,0 +1,7 @@
+//go:build !(linux || darwin || freebsd)
+
+package health
+
+func freeSpace(string) (uint64, error) {
+	return 0, errNotSupported
+}
@@ -0,0 +1,89 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/esavich/otus_project/internal/logger"
+)
+
+// checkTimeout limits the time of a single readiness check.
+const checkTimeout = 2 * time.Second
+
+type CheckFunc func(ctx context.Context) error
+
+type Check struct {
+	Name string
+	Fn   CheckFunc
+}
+
+type checkResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type report struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+type Handler struct {
+	checks []Check
+}
+
+func NewHealthHandler(checks ...Check) *Handler {
+	return &Handler{
+		checks: checks,
+	}
+}
+
+// Live reports that the process is up and able to serve http.
+func (h *Handler) Live(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+// Ready runs all checks, add ?verbose to get the status of every check as json.
+func (h *Handler) Ready(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	rep := report{
+		Status: "ok",
+		Checks: make(map[string]checkResult, len(h.checks)),
+	}
+	for _, check := range h.checks {
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		err := check.Fn(ctx)
+		cancel()
+
+		if err != nil {
+			log.Warn("Readiness check failed", slog.String("check", check.Name), slog.String("error", err.Error()))
+			rep.Status = "fail"
+			rep.Checks[check.Name] = checkResult{Status: "fail", Error: err.Error()}
+			continue
+		}
+		rep.Checks[check.Name] = checkResult{Status: "ok"}
+	}
+
+	status := http.StatusOK
+	if rep.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+
+	if !r.URL.Query().Has("verbose") {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		w.Write([]byte(rep.Status))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(rep)
+	if err != nil {
+		log.Error(err.Error())
+	}
+}
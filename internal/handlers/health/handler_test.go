@@ -0,0 +1,102 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func okCheck(context.Context) error {
+	return nil
+}
+
+func failCheck(context.Context) error {
+	return errors.New("broken")
+}
+
+func TestLive(t *testing.T) {
+	h := NewHealthHandler(Check{Name: "fail", Fn: failCheck})
+
+	rec := httptest.NewRecorder()
+	h.Live(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	// liveness does not depend on readiness checks
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "ok", rec.Body.String())
+}
+
+func TestReady(t *testing.T) {
+	t.Run("all ok", func(t *testing.T) {
+		h := NewHealthHandler(Check{Name: "first", Fn: okCheck}, Check{Name: "second", Fn: okCheck})
+
+		rec := httptest.NewRecorder()
+		h.Ready(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "ok", rec.Body.String())
+	})
+
+	t.Run("one failed", func(t *testing.T) {
+		h := NewHealthHandler(Check{Name: "first", Fn: okCheck}, Check{Name: "second", Fn: failCheck})
+
+		rec := httptest.NewRecorder()
+		h.Ready(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		require.Equal(t, "fail", rec.Body.String())
+	})
+
+	t.Run("verbose", func(t *testing.T) {
+		h := NewHealthHandler(Check{Name: "first", Fn: okCheck}, Check{Name: "second", Fn: failCheck})
+
+		rec := httptest.NewRecorder()
+		h.Ready(rec, httptest.NewRequest(http.MethodGet, "/readyz?verbose", nil))
+
+		require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+		var rep report
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &rep))
+		require.Equal(t, "fail", rep.Status)
+		require.Equal(t, checkResult{Status: "ok"}, rep.Checks["first"])
+		require.Equal(t, checkResult{Status: "fail", Error: "broken"}, rep.Checks["second"])
+	})
+}
+
+func TestDirWritable(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, DirWritable(dir)(context.Background()))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries, "temp file must be removed")
+
+	require.Error(t, DirWritable(filepath.Join(dir, "not-exist"))(context.Background()))
+}
+
+func TestFreeSpace(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, FreeSpace(dir, 1)(context.Background()))
+
+	if _, err := freeSpace(dir); errors.Is(err, errNotSupported) {
+		t.Skip("free space is not supported on this platform")
+	}
+	require.ErrorContains(t, FreeSpace(dir, math.MaxUint64)(context.Background()), "not enough disk space")
+}
+
+func TestNotDraining(t *testing.T) {
+	draining := false
+	check := NotDraining(func() bool { return draining })
+	require.NoError(t, check(context.Background()))
+
+	draining = true
+	require.Error(t, check(context.Background()))
+}
@@ -1,6 +1,8 @@
 package resize
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"image"
 	"image/jpeg"
@@ -9,10 +11,23 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+
+	"github.com/esavich/otus_project/internal/logger"
+	"github.com/esavich/otus_project/internal/ratelimit"
+	"github.com/esavich/otus_project/internal/workerpool"
 )
 
+// seconds the client should wait when resize workers are overloaded.
+const overloadRetryAfter = "1"
+
+var tracer = otel.Tracer("github.com/esavich/otus_project/internal/handlers/resize")
+
 type ImageGetter interface {
-	GetResizedImage(width, height int, imgURL string, header http.Header) (image.Image, error)
+	GetResizedImage(ctx context.Context, width, height int, imgURL string, header http.Header) (image.Image, error)
 }
 
 type Handler struct {
@@ -26,6 +41,10 @@ func NewResizeHandler(ig ImageGetter) *Handler {
 }
 
 func (h *Handler) Resize(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "resize.Handler")
+	defer span.End()
+	log := logger.FromContext(ctx)
+
 	width := r.PathValue("width")
 	height := r.PathValue("height")
 	imgURL := r.PathValue("url")
@@ -51,10 +70,26 @@ func (h *Handler) Resize(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid URL parameter: not jpeg", http.StatusBadRequest)
 		return
 	}
-	slog.Info("Params", slog.Int("width", iw), slog.Int("height", ih), slog.String("url", imgURL))
+	if u, err := url.Parse(imgURL); err == nil {
+		logger.AddAccessAttrs(ctx, slog.String("source_host", u.Host))
+	}
+	log.Info("Params", slog.Int("width", iw), slog.Int("height", ih), slog.String("url", imgURL))
+	span.SetAttributes(attribute.Int("width", iw), attribute.Int("height", ih), attribute.String("url.full", imgURL))
 
-	resized, err := h.ig.GetResizedImage(iw, ih, imgURL, r.Header)
+	resized, err := h.ig.GetResizedImage(ctx, iw, ih, imgURL, r.Header)
+	if errors.Is(err, workerpool.ErrQueueFull) || errors.Is(err, workerpool.ErrClosed) {
+		w.Header().Set("Retry-After", overloadRetryAfter)
+		http.Error(w, "Server is overloaded, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	var limitErr *ratelimit.Error
+	if errors.As(err, &limitErr) {
+		w.Header().Set("Retry-After", limitErr.RetryAfterHeader())
+		http.Error(w, "Too many requests, try again later", http.StatusTooManyRequests)
+		return
+	}
 	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
 		http.Error(w, "Cant get image: "+err.Error(), http.StatusBadGateway)
 		return
 	}
@@ -62,7 +97,7 @@ func (h *Handler) Resize(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "image/jpeg")
 	err = jpeg.Encode(w, resized, nil)
 	if err != nil {
-		slog.Error(err.Error())
+		log.Error(err.Error())
 		http.Error(w, "Cant encode image: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
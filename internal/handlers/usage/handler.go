@@ -0,0 +1,52 @@
+package usage
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/esavich/otus_project/internal/apikey"
+	"github.com/esavich/otus_project/internal/logger"
+)
+
+type Reporter interface {
+	Usage() map[string]apikey.KeyUsage
+	KeyUsage(secret string) (string, apikey.KeyUsage, error)
+}
+
+type Handler struct {
+	reporter Reporter
+}
+
+func NewUsageHandler(reporter Reporter) *Handler {
+	return &Handler{
+		reporter: reporter,
+	}
+}
+
+// Usage reports today's usage and quotas of every api key by key name.
+func (h *Handler) Usage(w http.ResponseWriter, r *http.Request) {
+	h.write(w, r, h.reporter.Usage())
+}
+
+// KeyUsage reports today's usage and quotas of the api key of the request only.
+// The report is not counted against the quotas of the key.
+func (h *Handler) KeyUsage(w http.ResponseWriter, r *http.Request) {
+	name, usage, err := h.reporter.KeyUsage(apikey.FromRequest(r))
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", "ApiKey")
+		http.Error(w, "Invalid or missing api key", http.StatusUnauthorized)
+		return
+	}
+	logger.AddAccessAttrs(r.Context(), slog.String("api_key", name))
+
+	h.write(w, r, map[string]apikey.KeyUsage{name: usage})
+}
+
+func (h *Handler) write(w http.ResponseWriter, r *http.Request, report map[string]apikey.KeyUsage) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(report)
+	if err != nil {
+		logger.FromContext(r.Context()).Error(err.Error())
+	}
+}
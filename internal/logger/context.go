@@ -0,0 +1,78 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+)
+
+type loggerKey struct{}
+
+type accessKey struct{}
+
+type requestIDKey struct{}
+
+// WithLogger returns a context carrying a request scoped logger.
+func WithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the request scoped logger or the default one.
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+
+	return slog.Default()
+}
+
+// WithRequestID returns a context carrying the id of the request, so it can be passed on to origins.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the id of the request or an empty string.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// AccessAttrs collects attributes for the access log line of a request.
+type AccessAttrs struct {
+	mutex sync.Mutex
+	attrs []slog.Attr
+}
+
+// WithAccessAttrs returns a context where handlers can add attributes to the access log.
+func WithAccessAttrs(ctx context.Context) (context.Context, *AccessAttrs) {
+	aa := &AccessAttrs{}
+	return context.WithValue(ctx, accessKey{}, aa), aa
+}
+
+// AddAccessAttrs adds attributes to the access log line of the current request, if any.
+// An attribute replaces the earlier one with the same key.
+func AddAccessAttrs(ctx context.Context, attrs ...slog.Attr) {
+	aa, ok := ctx.Value(accessKey{}).(*AccessAttrs)
+	if !ok {
+		return
+	}
+
+	aa.mutex.Lock()
+	defer aa.mutex.Unlock()
+	for _, attr := range attrs {
+		i := slices.IndexFunc(aa.attrs, func(a slog.Attr) bool { return a.Key == attr.Key })
+		if i >= 0 {
+			aa.attrs[i] = attr
+			continue
+		}
+		aa.attrs = append(aa.attrs, attr)
+	}
+}
+
+func (aa *AccessAttrs) Attrs() []slog.Attr {
+	aa.mutex.Lock()
+	defer aa.mutex.Unlock()
+
+	return append([]slog.Attr(nil), aa.attrs...)
+}
@@ -8,26 +8,38 @@ import (
 	"github.com/esavich/otus_project/internal/config"
 )
 
+// level is shared by all loggers, so it can be changed at runtime.
+var level = new(slog.LevelVar)
+
 func SetupLogger(cfg *config.Config) {
 	// Set the log level based on the configuration
-	var level slog.Level
-	switch cfg.App.LogLevel {
-	case "debug":
-		level = slog.LevelDebug
-	case "info":
-		level = slog.LevelInfo
-	case "warn":
-		level = slog.LevelWarn
-	case "error":
-		level = slog.LevelError
-	default:
-		level = slog.LevelInfo
-	}
+	level.Set(parseLevel(cfg.App.LogLevel))
 	opts := slog.HandlerOptions{}
 	opts.Level = level
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, &opts)).
 		With(slog.String("service", cfg.App.ServiceName))
 	slog.SetDefault(logger)
 	slog.Info("Logger initialized")
-	slog.Info(fmt.Sprintf("Logger set to level: %s", opts.Level))
+	slog.Info(fmt.Sprintf("Logger set to level: %s", level.Level()))
+}
+
+// SetLevel changes the level of running loggers.
+func SetLevel(name string) {
+	level.Set(parseLevel(name))
+	slog.Info(fmt.Sprintf("Logger set to level: %s", level.Level()))
+}
+
+func parseLevel(name string) slog.Level {
+	switch name {
+	case "debug":
+		return slog.LevelDebug
+	case "info":
+		return slog.LevelInfo
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
 }
@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/esavich/otus_project/internal/diskcache"
+)
+
+type cacheStatser interface {
+	Stats() diskcache.Stats
+}
+
+// RegisterCache exposes hits, misses, evictions, corrupted files and size of the cache.
+func RegisterCache(c cacheStatser) {
+	prometheus.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_hits_total",
+			Help:      "Number of cache hits.",
+		}, func() float64 { return float64(c.Stats().Hits) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_misses_total",
+			Help:      "Number of cache misses.",
+		}, func() float64 { return float64(c.Stats().Misses) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_evictions_total",
+			Help:      "Number of entries evicted from the cache.",
+		}, func() float64 { return float64(c.Stats().Evictions) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_corrupted_total",
+			Help:      "Number of cached files that failed the checksum and were evicted.",
+		}, func() float64 { return float64(c.Stats().Corrupted) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "cache_items",
+			Help:      "Current number of cached entries.",
+		}, func() float64 { return float64(c.Stats().Items) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "cache_bytes",
+			Help:      "Current size of cached entries on disk.",
+		}, func() float64 { return float64(c.Stats().Bytes) }),
+	)
+}
+
+type queue interface {
+	QueueDepth() int
+}
+
+// RegisterWorkerQueue exposes the number of jobs waiting for a resize worker.
+func RegisterWorkerQueue(q queue) {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "resize_queue_depth",
+		Help:      "Number of resize jobs waiting for a worker.",
+	}, func() float64 { return float64(q.QueueDepth()) }))
+}
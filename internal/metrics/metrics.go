@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/esavich/otus_project/internal/cache"
+)
+
+const namespace = "resizer"
+
+// maxHostLabels bounds the cardinality of download host labels, hosts come from client requests.
+// Series of the least recently seen host are deleted when another host shows up.
+// The breaker state has a series per tracked breaker instead, they are bounded by the downloader.
+const maxHostLabels = 100
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of http requests by route and status.",
+	}, []string{"route", "status"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Http request latency by route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "status"})
+
+	downloadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "download_duration_seconds",
+		Help:      "Origin download latency by host.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"host"})
+
+	downloadBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "download_bytes_total",
+		Help:      "Bytes downloaded from origins by host.",
+	}, []string{"host"})
+
+	breakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "circuit_breaker_state",
+		Help:      "Circuit breaker state by origin host: 0 closed, 1 open, 2 half-open.",
+	}, []string{"host"})
+
+	rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Requests rejected by rate limits, by limit name.",
+	}, []string{"limit"})
+
+	apiKeyRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_key_requests_total",
+		Help:      "Authenticated requests by api key name.",
+	}, []string{"key"})
+
+	apiKeyBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_key_bytes_total",
+		Help:      "Response bytes served by api key name.",
+	}, []string{"key"})
+
+	resizeDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "resize_duration_seconds",
+		Help:      "Time spent resizing images.",
+		Buckets:   prometheus.DefBuckets,
+	})
+)
+
+// Handler serves all registered metrics in the prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+func ObserveRequest(route string, status int, d time.Duration) {
+	code := strconv.Itoa(status)
+	requestsTotal.WithLabelValues(route, code).Inc()
+	requestDuration.WithLabelValues(route, code).Observe(d.Seconds())
+}
+
+var (
+	// hostsMutex keeps series of a host from being deleted between tracking and observing it
+	hostsMutex sync.Mutex
+	hosts      = cache.NewLRU[string, string](maxHostLabels)
+)
+
+// trackHost marks the host as recently seen, the caller holds hostsMutex.
+func trackHost(host string) {
+	hosts.Set(host, host, func(old string) {
+		downloadDuration.DeleteLabelValues(old)
+		downloadBytes.DeleteLabelValues(old)
+	})
+}
+
+func ObserveDownload(host string, bytes int, d time.Duration) {
+	hostsMutex.Lock()
+	defer hostsMutex.Unlock()
+
+	trackHost(host)
+	downloadDuration.WithLabelValues(host).Observe(d.Seconds())
+	downloadBytes.WithLabelValues(host).Add(float64(bytes))
+}
+
+func SetBreakerState(host string, state int) {
+	breakerState.WithLabelValues(host).Set(float64(state))
+}
+
+// DeleteBreakerState removes the series of a breaker the downloader no longer tracks.
+func DeleteBreakerState(host string) {
+	breakerState.DeleteLabelValues(host)
+}
+
+func ObserveResize(d time.Duration) {
+	resizeDuration.Observe(d.Seconds())
+}
+
+func ObserveRateLimited(limit string) {
+	rateLimited.WithLabelValues(limit).Inc()
+}
+
+func ObserveAPIKeyUsage(key string, bytes int) {
+	apiKeyRequests.WithLabelValues(key).Inc()
+	apiKeyBytes.WithLabelValues(key).Add(float64(bytes))
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	ObserveRequest("GET /fill/{width}/{height}/{url...}", http.StatusOK, time.Millisecond)
+	ObserveDownload("origin.example.com", 10, time.Millisecond)
+	SetBreakerState("origin.example.com", 1)
+	ObserveResize(time.Millisecond)
+
+	body := scrape(t)
+	require.Contains(t, body, `resizer_http_requests_total{route="GET /fill/{width}/{height}/{url...}",status="200"} 1`)
+	require.Contains(t, body, `resizer_download_bytes_total{host="origin.example.com"} 10`)
+	require.Contains(t, body, `resizer_circuit_breaker_state{host="origin.example.com"} 1`)
+	require.Contains(t, body, "resizer_resize_duration_seconds_count 1")
+}
+
+func TestObserveDownload_BoundedHosts(t *testing.T) {
+	for i := range maxHostLabels * 2 {
+		host := "host-" + strconv.Itoa(i) + ".example.com"
+		ObserveDownload(host, 10, time.Millisecond)
+		SetBreakerState(host, 1)
+	}
+
+	body := scrape(t)
+	require.Equal(t, maxHostLabels, strings.Count(body, "resizer_download_bytes_total{"))
+	// the most recent hosts are kept
+	require.Contains(t, body, `resizer_download_bytes_total{host="host-`+strconv.Itoa(maxHostLabels*2-1)+`.example.com"}`)
+	require.NotContains(t, body, `resizer_download_bytes_total{host="host-0.example.com"}`)
+	// breaker states are not dropped with the download series, an open breaker stays visible
+	require.Contains(t, body, `resizer_circuit_breaker_state{host="host-0.example.com"} 1`)
+
+	DeleteBreakerState("host-0.example.com")
+	require.NotContains(t, scrape(t), `resizer_circuit_breaker_state{host="host-0.example.com"}`)
+}
+
+func scrape(t *testing.T) string {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	return rec.Body.String()
+}
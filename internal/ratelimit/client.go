@@ -0,0 +1,145 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error is returned when a client is over its limit.
+type Error struct {
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return "rate limit exceeded"
+}
+
+// RetryAfterHeader returns the Retry-After value in whole seconds, at least one.
+func (e *Error) RetryAfterHeader() string {
+	return strconv.Itoa(max(1, int(math.Ceil(e.RetryAfter.Seconds()))))
+}
+
+// KeyFunc identifies the client of the request.
+type KeyFunc func(r *http.Request) string
+
+// ClientIP identifies clients by ip. X-Forwarded-For is used only when the request
+// comes from a trusted proxy, the client is the rightmost address that is not a trusted proxy.
+func ClientIP(trusted []netip.Prefix) KeyFunc {
+	isTrusted := func(addr netip.Addr) bool {
+		for _, p := range trusted {
+			if p.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(r *http.Request) string {
+		remote := remoteAddr(r)
+		if !remote.IsValid() || !isTrusted(remote) {
+			return remote.String()
+		}
+
+		var forwarded []string
+		for _, value := range r.Header.Values("X-Forwarded-For") {
+			forwarded = append(forwarded, strings.Split(value, ",")...)
+		}
+
+		client := remote
+		for i := len(forwarded) - 1; i >= 0; i-- {
+			addr, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+			if err != nil {
+				break
+			}
+			client = addr.Unmap()
+			if !isTrusted(client) {
+				break
+			}
+		}
+
+		return client.String()
+	}
+}
+
+// APIKey identifies clients by the name of their api key. Clients with an unknown or without a key
+// fall back to the next key func, so made up keys don't get buckets of their own.
+func APIKey(name func(r *http.Request) (string, bool), fallback KeyFunc) KeyFunc {
+	return func(r *http.Request) string {
+		if key, ok := name(r); ok {
+			return "key:" + key
+		}
+		return fallback(r)
+	}
+}
+
+func remoteAddr(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+
+	return addr.Unmap()
+}
+
+// ParsePrefixes parses trusted proxy networks, single addresses are allowed too.
+func ParsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", value, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", value, err)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+
+	return prefixes, nil
+}
+
+type (
+	clientKey struct{}
+	exemptKey struct{}
+)
+
+// WithClient stores the client key, so limits deeper in the stack apply to the same client.
+func WithClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+func ClientFromContext(ctx context.Context) string {
+	client, _ := ctx.Value(clientKey{}).(string)
+	return client
+}
+
+// WithoutLimits marks trusted internal work, like cache warm-up, so limits deeper in the stack don't apply.
+func WithoutLimits(ctx context.Context) context.Context {
+	return context.WithValue(ctx, exemptKey{}, true)
+}
+
+// Exempt reports whether limits don't apply to ctx.
+func Exempt(ctx context.Context) bool {
+	exempt, _ := ctx.Value(exemptKey{}).(bool)
+	return exempt
+}
@@ -0,0 +1,127 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	l := New(Limit{Rate: 2, Burst: 3})
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("a")
+		require.True(t, ok)
+	}
+	ok, wait := l.Allow("a")
+	require.False(t, ok)
+	require.Equal(t, 500*time.Millisecond, wait)
+
+	// other clients have their own bucket
+	ok, _ = l.Allow("b")
+	require.True(t, ok)
+
+	now = now.Add(500 * time.Millisecond)
+	ok, _ = l.Allow("a")
+	require.True(t, ok)
+	ok, _ = l.Allow("a")
+	require.False(t, ok)
+}
+
+func TestLimiter_Unlimited(t *testing.T) {
+	l := New(Limit{})
+	for i := 0; i < 100; i++ {
+		ok, _ := l.Allow("a")
+		require.True(t, ok)
+	}
+}
+
+func TestLimiter_Sweep(t *testing.T) {
+	now := time.Now()
+	l := New(Limit{Rate: 1, Burst: 1})
+	l.now = func() time.Time { return now }
+
+	l.Allow("a")
+	require.Len(t, l.buckets, 1)
+
+	now = now.Add(sweepInterval)
+	l.Allow("b")
+	require.Len(t, l.buckets, 1)
+	require.Contains(t, l.buckets, "b")
+}
+
+func TestParseLimit(t *testing.T) {
+	limit, err := ParseLimit("10/20")
+	require.NoError(t, err)
+	require.Equal(t, Limit{Rate: 10, Burst: 20}, limit)
+
+	limit, err = ParseLimit("0.5")
+	require.NoError(t, err)
+	require.Equal(t, Limit{Rate: 0.5, Burst: 1}, limit)
+
+	for _, value := range []string{"", "abc", "-1", "1/x"} {
+		_, err = ParseLimit(value)
+		require.Error(t, err, value)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParsePrefixes([]string{"10.0.0.0/8", "192.168.1.1"})
+	require.NoError(t, err)
+	key := ClientIP(trusted)
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded string
+		expected  string
+	}{
+		{name: "direct", remote: "1.2.3.4:5000", expected: "1.2.3.4"},
+		{name: "spoofed header from untrusted", remote: "1.2.3.4:5000", forwarded: "5.6.7.8", expected: "1.2.3.4"},
+		{name: "trusted proxy", remote: "10.0.0.1:5000", forwarded: "5.6.7.8", expected: "5.6.7.8"},
+		{name: "proxy chain", remote: "10.0.0.1:5000", forwarded: "9.9.9.9, 5.6.7.8, 192.168.1.1", expected: "5.6.7.8"},
+		{name: "trusted without header", remote: "10.0.0.1:5000", expected: "10.0.0.1"},
+		{name: "invalid entry", remote: "10.0.0.1:5000", forwarded: "garbage", expected: "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			require.Equal(t, tt.expected, key(r))
+		})
+	}
+}
+
+func TestAPIKey(t *testing.T) {
+	names := map[string]string{"secret-a": "team-a"}
+	key := APIKey(func(r *http.Request) (string, bool) {
+		name, ok := names[r.Header.Get("X-API-Key")]
+		return name, ok
+	}, ClientIP(nil))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "1.2.3.4:5000"
+	require.Equal(t, "1.2.3.4", key(r))
+
+	r.Header.Set("X-API-Key", "secret-a")
+	require.Equal(t, "key:team-a", key(r))
+
+	// random keys share the ip bucket
+	r.Header.Set("X-API-Key", "random-1")
+	require.Equal(t, "1.2.3.4", key(r))
+	r.Header.Set("X-API-Key", "random-2")
+	require.Equal(t, "1.2.3.4", key(r))
+}
+
+func TestError_RetryAfterHeader(t *testing.T) {
+	require.Equal(t, "1", (&Error{RetryAfter: 100 * time.Millisecond}).RetryAfterHeader())
+	require.Equal(t, "3", (&Error{RetryAfter: 2500 * time.Millisecond}).RetryAfterHeader())
+}
@@ -0,0 +1,65 @@
+package reload
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Watch calls fn on SIGHUP and when any of the files is modified, until ctx is done.
+// Files are polled every interval, zero interval disables polling. Missing files are ignored.
+func Watch(ctx context.Context, files []string, interval time.Duration, fn func()) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if interval > 0 && len(files) > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	seen := modTimes(files)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			seen = modTimes(files)
+			fn()
+		case <-tick:
+			current := modTimes(files)
+			if changed(seen, current) {
+				seen = current
+				fn()
+			}
+		}
+	}
+}
+
+func modTimes(files []string) map[string]time.Time {
+	times := make(map[string]time.Time, len(files))
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			times[file] = info.ModTime()
+		}
+	}
+
+	return times
+}
+
+func changed(old, current map[string]time.Time) bool {
+	if len(old) != len(current) {
+		return true
+	}
+	for file, t := range current {
+		if !old[file].Equal(t) {
+			return true
+		}
+	}
+
+	return false
+}
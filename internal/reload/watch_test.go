@@ -0,0 +1,48 @@
+//go:build unix
+
+package reload
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(file, []byte("a"), 0o600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls atomic.Int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Watch(ctx, []string{file, filepath.Join(dir, "missing")}, 10*time.Millisecond, func() { calls.Add(1) })
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// nothing changed yet
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, int32(0), calls.Load())
+
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(file, future, future))
+	require.Eventually(t, func() bool { return calls.Load() == 1 }, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	require.Eventually(t, func() bool { return calls.Load() == 2 }, time.Second, 10*time.Millisecond)
+
+	// a new file appearing is a change too
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "missing"), nil, 0o600))
+	require.Eventually(t, func() bool { return calls.Load() == 3 }, time.Second, 10*time.Millisecond)
+}
@@ -1,19 +1,84 @@
 package resizer
 
 import (
+	"context"
+	"fmt"
 	"image"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/disintegration/imaging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/esavich/otus_project/internal/metrics"
 )
 
+var tracer = otel.Tracer("github.com/esavich/otus_project/internal/resizer")
+
+// Mode is how the image is fitted into the requested size.
+type Mode string
+
+const (
+	// Fill scales and crops the image to exactly the requested size.
+	Fill Mode = "fill"
+	// Fit scales the image to fit into the requested size keeping the aspect ratio.
+	Fit Mode = "fit"
+)
+
+// ParseMode validates a mode name.
+func ParseMode(name string) (Mode, error) {
+	switch mode := Mode(name); mode {
+	case Fill, Fit:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown resize mode: %s", name)
+	}
+}
+
+// ParseSize parses "WIDTHxHEIGHT".
+func ParseSize(value string) (int, int, error) {
+	w, h, ok := strings.Cut(strings.ToLower(value), "x")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid size %q, expected WIDTHxHEIGHT", value)
+	}
+	width, err := strconv.Atoi(w)
+	if err != nil || width <= 0 {
+		return 0, 0, fmt.Errorf("invalid width: %s", w)
+	}
+	height, err := strconv.Atoi(h)
+	if err != nil || height <= 0 {
+		return 0, 0, fmt.Errorf("invalid height: %s", h)
+	}
+
+	return width, height, nil
+}
+
 type Resizer struct{}
 
 func NewResizer() *Resizer {
 	return &Resizer{}
 }
 
-func (*Resizer) ResizeImg(img image.Image, w int, p int) image.Image {
-	resized := imaging.Fill(img, w, p, imaging.Center, imaging.Lanczos)
+func (r *Resizer) ResizeImg(ctx context.Context, img image.Image, w int, p int) image.Image {
+	return r.Resize(ctx, img, w, p, Fill)
+}
+
+// Resize resizes img to w x p with the given mode, unknown modes fill.
+func (*Resizer) Resize(ctx context.Context, img image.Image, w int, p int, mode Mode) image.Image {
+	_, span := tracer.Start(ctx, "Resizer.ResizeImg")
+	defer span.End()
+	span.SetAttributes(attribute.Int("width", w), attribute.Int("height", p), attribute.String("mode", string(mode)))
+
+	start := time.Now()
+	var resized image.Image
+	if mode == Fit {
+		resized = imaging.Fit(img, w, p, imaging.Lanczos)
+	} else {
+		resized = imaging.Fill(img, w, p, imaging.Center, imaging.Lanczos)
+	}
+	metrics.ObserveResize(time.Since(start))
 
 	return resized
 }
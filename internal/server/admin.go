@@ -0,0 +1,66 @@
+package server
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"time"
+
+	"github.com/esavich/otus_project/internal/handlers/admin"
+	"github.com/esavich/otus_project/internal/handlers/usage"
+	"github.com/esavich/otus_project/internal/metrics"
+)
+
+// newAdminServer creates the listener for metrics, pprof, cache admin and config inspection.
+func (s *Server) newAdminServer() *http.Server {
+	mux := http.NewServeMux()
+
+	mux.Handle("GET /metrics", metrics.Handler())
+
+	mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+	mux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+
+	ch := admin.NewConfigHandler(func() any { return s.current.Load().Redacted() })
+	mux.HandleFunc("GET /admin/config", ch.Config)
+
+	if s.cacheAdmin != nil {
+		s.cacheAdminRoutes(mux, func(next http.Handler) http.Handler { return next })
+	}
+	if s.apiKeys != nil {
+		s.usageAdminRoute(mux, func(next http.Handler) http.Handler { return next })
+	}
+
+	var handler http.Handler = recordRoute(mux)
+	if s.Config.HTTP.AdminToken != "" {
+		handler = s.adminOnly(handler)
+	} else {
+		slog.Warn("Admin listener has no ADMIN_TOKEN, it relies on the network to restrict access")
+	}
+
+	return &http.Server{
+		Addr:              net.JoinHostPort(s.Config.HTTP.AdminHost, strconv.Itoa(s.Config.HTTP.AdminPort)),
+		Handler:           traced(instrument(accessLog(handler))),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+func (s *Server) usageAdminRoute(mux *http.ServeMux, wrap func(http.Handler) http.Handler) {
+	uh := usage.NewUsageHandler(s.apiKeys)
+	mux.Handle("GET /admin/usage", wrap(http.HandlerFunc(uh.Usage)))
+}
+
+func (s *Server) cacheAdminRoutes(mux *http.ServeMux, wrap func(http.Handler) http.Handler) {
+	ah := admin.NewAdminHandler(s.cacheAdmin, s.failures)
+	mux.Handle("GET /admin/cache/stats", wrap(http.HandlerFunc(ah.Stats)))
+	mux.Handle("GET /admin/cache/entries", wrap(http.HandlerFunc(ah.Entries)))
+	mux.Handle("DELETE /admin/cache", wrap(http.HandlerFunc(ah.Purge)))
+
+	wh := admin.NewWarmupHandler(s.service, s.Config.Cache.WarmupConcurrency)
+	mux.Handle("POST /admin/cache/warmup", wrap(http.HandlerFunc(wh.Warmup)))
+	mux.Handle("GET /admin/cache/warmup/{id}", wrap(http.HandlerFunc(wh.Status)))
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/esavich/otus_project/internal/apikey"
+	"github.com/esavich/otus_project/internal/logger"
+	"github.com/esavich/otus_project/internal/metrics"
+	"github.com/esavich/otus_project/internal/ratelimit"
+)
+
+var tracer = otel.Tracer("github.com/esavich/otus_project/internal/server")
+
+// recorder remembers the response status and size and the route matched by the mux.
+// The outermost middleware creates it and the inner ones share it through the context,
+// so the response passes a single wrapper.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+	// pattern matched by the mux, which sets Request.Pattern only on its own request. That is a copy
+	// once a middleware changed the context, so recordRoute saves the pattern here.
+	pattern string
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+func (rec *recorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// route returns the matched pattern, it is known only after the mux served the request.
+func (rec *recorder) route(r *http.Request) string {
+	if rec.pattern != "" {
+		return rec.pattern
+	}
+
+	return r.Pattern
+}
+
+type recorderKey struct{}
+
+// withRecorder returns the recorder of an outer middleware, or wraps w in a new one and puts it into the context.
+func withRecorder(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request, *recorder) {
+	if rec, ok := r.Context().Value(recorderKey{}).(*recorder); ok {
+		return w, r, rec
+	}
+	rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+
+	return rec, r.WithContext(context.WithValue(r.Context(), recorderKey{}, rec)), rec
+}
+
+// recordRoute wraps the mux and saves the matched pattern for the outer middlewares.
+func recordRoute(mux http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.ServeHTTP(w, r)
+
+		if rec, ok := r.Context().Value(recorderKey{}).(*recorder); ok {
+			rec.pattern = r.Pattern
+		}
+	})
+}
+
+// instrument records request count and latency by route pattern and status.
+func instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		w, r, rec := withRecorder(w, r)
+
+		next.ServeHTTP(w, r)
+
+		// pattern is set by the mux, it keeps the label cardinality low
+		route := rec.route(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.ObserveRequest(route, rec.status, time.Since(start))
+	})
+}
+
+// traced starts a server span, continuing the trace from the incoming traceparent header.
+func traced(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+
+		w, r, rec := withRecorder(w, r.WithContext(ctx))
+		next.ServeHTTP(w, r)
+
+		// the route is known only after the mux matched the request
+		if route := rec.route(r); route != "" {
+			span.SetName(route)
+			span.SetAttributes(attribute.String("http.route", route))
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLen limits accepted client request ids, longer ones are replaced.
+const maxRequestIDLen = 128
+
+// accessLog assigns a request id, puts a request scoped logger into the context
+// and writes one access log line per request.
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		l := slog.Default().With(slog.String("request_id", id))
+		if sc := trace.SpanContextFromContext(r.Context()); sc.HasTraceID() {
+			l = l.With(slog.String("trace_id", sc.TraceID().String()))
+		}
+		ctx := logger.WithRequestID(logger.WithLogger(r.Context(), l), id)
+		ctx, access := logger.WithAccessAttrs(ctx)
+
+		w, r, rec := withRecorder(w, r.WithContext(ctx))
+		next.ServeHTTP(w, r)
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", rec.route(r)),
+			slog.Int("status", rec.status),
+			slog.Int("bytes", rec.bytes),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("remote_addr", r.RemoteAddr),
+		}
+		attrs = append(attrs, access.Attrs()...)
+		l.LogAttrs(ctx, slog.LevelInfo, "access", attrs...)
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// identifyClient puts the rate limit client key into the context.
+func identifyClient(key ratelimit.KeyFunc, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(ratelimit.WithClient(r.Context(), key(r))))
+	})
+}
+
+// rateLimit rejects requests of clients over the route limit with 429.
+func rateLimit(route string, limiter *ratelimit.Limiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := ratelimit.ClientFromContext(r.Context())
+		if ok, wait := limiter.Allow(client); !ok {
+			logger.FromContext(r.Context()).Info("Rate limit exceeded", slog.String("client", client))
+			logger.AddAccessAttrs(r.Context(), slog.String("rate_limited", route))
+			metrics.ObserveRateLimited(route)
+
+			limitErr := &ratelimit.Error{RetryAfter: wait}
+			w.Header().Set("Retry-After", limitErr.RetryAfterHeader())
+			http.Error(w, "Too many requests, try again later", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate requires a known api key within its daily quotas and counts the served bytes.
+func authenticate(acc *apikey.Accounting, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := acc.Begin(apikey.FromRequest(r))
+		var quotaErr *apikey.QuotaError
+		switch {
+		case errors.As(err, &quotaErr):
+			logger.AddAccessAttrs(r.Context(), slog.String("api_key", key.Name), slog.Bool("quota_exceeded", true))
+			w.Header().Set("Retry-After", quotaErr.RetryAfterHeader())
+			http.Error(w, "Daily quota exceeded", http.StatusTooManyRequests)
+			return
+		case err != nil:
+			w.Header().Set("WWW-Authenticate", "ApiKey")
+			http.Error(w, "Invalid or missing api key", http.StatusUnauthorized)
+			return
+		}
+		logger.AddAccessAttrs(r.Context(), slog.String("api_key", key.Name))
+
+		// bytes of an outer recorder are all written by next, nothing else writes after the key is accepted
+		w, r, rec := withRecorder(w, r)
+		next.ServeHTTP(w, r)
+
+		acc.AddBytes(key.Name, int64(rec.bytes))
+		metrics.ObserveAPIKeyUsage(key.Name, rec.bytes)
+	})
+}
+
+// bearerAuth requires the Authorization: Bearer <token> header.
+func bearerAuth(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/esavich/otus_project/internal/apikey"
+	"github.com/esavich/otus_project/internal/logger"
+	"github.com/esavich/otus_project/internal/ratelimit"
+)
+
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	oldLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(oldLogger) })
+
+	return &buf
+}
+
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var m map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &m))
+		lines = append(lines, m)
+	}
+
+	return lines
+}
+
+func TestAccessLog(t *testing.T) {
+	buf := captureLogs(t)
+
+	h := accessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.FromContext(r.Context()).Info("inside handler")
+		logger.AddAccessAttrs(r.Context(), slog.String("cache", "miss"))
+		logger.AddAccessAttrs(r.Context(), slog.String("cache", "hit"))
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("body"))
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fill/1/1/a.jpg", nil))
+
+	id := rec.Header().Get(requestIDHeader)
+	require.Len(t, id, 32)
+
+	lines := logLines(t, buf)
+	require.Len(t, lines, 2)
+	for _, line := range lines {
+		require.Equal(t, id, line["request_id"])
+	}
+
+	access := lines[1]
+	require.Equal(t, "access", access["msg"])
+	require.InDelta(t, http.StatusTeapot, access["status"], 0)
+	require.InDelta(t, 4, access["bytes"], 0)
+	require.Equal(t, "hit", access["cache"])
+	require.Equal(t, 1, strings.Count(buf.String(), `"cache":`), "a later value replaces the earlier one")
+}
+
+func TestRecorder_Shared(t *testing.T) {
+	captureLogs(t)
+
+	var inner http.ResponseWriter
+	h := instrument(accessLog(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		inner = w
+		w.Write([]byte("body"))
+	})))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	wrapper, ok := inner.(*recorder)
+	require.True(t, ok)
+	require.Same(t, rec, wrapper.Unwrap(), "the inner middleware reuses the outer recorder")
+	require.Equal(t, 4, wrapper.bytes)
+}
+
+func TestAccessLog_RequestID(t *testing.T) {
+	captureLogs(t)
+	h := accessLog(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	t.Run("accepted from client", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(requestIDHeader, "client-id-1")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		require.Equal(t, "client-id-1", rec.Header().Get(requestIDHeader))
+	})
+
+	t.Run("invalid is replaced", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(requestIDHeader, "bad id\n")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		require.NotEqual(t, "bad id\n", rec.Header().Get(requestIDHeader))
+		require.Len(t, rec.Header().Get(requestIDHeader), 32)
+	})
+}
+
+func TestRateLimit(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.Limit{Rate: 1, Burst: 2})
+	h := identifyClient(ratelimit.ClientIP(nil), rateLimit("fill", limiter, http.HandlerFunc(
+		func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})))
+
+	request := func(remote string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/fill/1/1/a.jpg", nil)
+		r.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec
+	}
+
+	require.Equal(t, http.StatusOK, request("1.2.3.4:1000").Code)
+	require.Equal(t, http.StatusOK, request("1.2.3.4:1001").Code)
+
+	rec := request("1.2.3.4:1002")
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	require.Equal(t, http.StatusOK, request("5.6.7.8:1000").Code)
+}
+
+func TestAuthenticate(t *testing.T) {
+	acc, err := apikey.NewAccounting(map[string]apikey.Key{
+		"secret": {Name: "team", RequestsPerDay: 2},
+	}, filepath.Join(t.TempDir(), "usage.json"))
+	require.NoError(t, err)
+
+	h := authenticate(acc, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("image"))
+	}))
+
+	request := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	require.Equal(t, http.StatusUnauthorized, request("/fill/1/1/a.jpg").Code)
+	require.Equal(t, http.StatusUnauthorized, request("/fill/1/1/a.jpg?api_key=wrong").Code)
+	require.Equal(t, http.StatusOK, request("/fill/1/1/a.jpg?api_key=secret").Code)
+	require.Equal(t, http.StatusOK, request("/fill/1/1/a.jpg?api_key=secret").Code)
+
+	rec := request("/fill/1/1/a.jpg?api_key=secret")
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+	usage := acc.Usage()["team"]
+	require.Equal(t, int64(2), usage.Requests)
+	require.Equal(t, int64(10), usage.Bytes)
+}
+
+func TestBearerAuth(t *testing.T) {
+	h := bearerAuth("secret", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for header, expected := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"secret":        http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"Bearer secret": http.StatusOK,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/admin/cache/stats", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		require.Equal(t, expected, rec.Code, header)
+	}
+}
@@ -1,48 +1,254 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"log/slog"
 	"net"
 	"net/http"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/esavich/otus_project/internal/apikey"
 	"github.com/esavich/otus_project/internal/config"
+	"github.com/esavich/otus_project/internal/handlers/admin"
+	"github.com/esavich/otus_project/internal/handlers/health"
 	"github.com/esavich/otus_project/internal/handlers/resize"
+	"github.com/esavich/otus_project/internal/handlers/usage"
+	"github.com/esavich/otus_project/internal/metrics"
+	"github.com/esavich/otus_project/internal/ratelimit"
 	"github.com/esavich/otus_project/internal/service"
 )
 
 type Server struct {
-	Config  *config.Config
-	service service.ImageGetter
+	Config   *config.Config
+	service  service.ImageGetter
+	server   *http.Server
+	admin    *http.Server
+	redirect *http.Server
+	draining atomic.Bool
+	stop     chan struct{}
+	stopOnce sync.Once
+	// the latest reloaded config, for inspection only
+	current atomic.Pointer[config.Config]
+
+	clientKey  ratelimit.KeyFunc
+	rateLimits map[string]*ratelimit.Limiter
+	apiKeys    *apikey.Accounting
+	cacheAdmin admin.Cache
+	failures   admin.Failures
+}
+
+type Option func(*Server)
+
+// RateLimitedRoutes are route names accepted by WithRateLimits.
+var RateLimitedRoutes = []string{"fill", "usage", "metrics", "healthz", "readyz"}
+
+// WithRateLimits limits requests per client on the named routes, see RateLimitedRoutes.
+// Routes without a limiter are not limited.
+func WithRateLimits(key ratelimit.KeyFunc, limits map[string]*ratelimit.Limiter) Option {
+	return func(s *Server) {
+		s.clientKey = key
+		s.rateLimits = limits
+	}
+}
+
+// WithAPIKeys requires api keys on the resize routes and serves the usage of the own key on /usage.
+// The usage of all keys is served on /admin/usage like the cache admin api.
+func WithAPIKeys(acc *apikey.Accounting) Option {
+	return func(s *Server) {
+		s.apiKeys = acc
+	}
+}
+
+// WithCacheAdmin serves the cache admin api under /admin/cache. It is served on the admin listener,
+// or on the public one when there is no admin listener and HTTP.AdminToken is set.
+func WithCacheAdmin(cache admin.Cache) Option {
+	return func(s *Server) {
+		s.cacheAdmin = cache
+	}
+}
+
+// WithNegativeCache lets cache admin purges also drop remembered failures of the purged sources.
+func WithNegativeCache(failures admin.Failures) Option {
+	return func(s *Server) {
+		s.failures = failures
+	}
 }
 
-func NewServer(cfg *config.Config, service service.ImageGetter) *Server {
-	return &Server{
+func NewServer(cfg *config.Config, service service.ImageGetter, opts ...Option) *Server {
+	s := &Server{
 		Config:  cfg,
 		service: service,
+		stop:    make(chan struct{}),
 	}
-}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.current.Store(cfg)
 
-func (s *Server) Start() error {
 	addr := net.JoinHostPort(s.Config.HTTP.Host, strconv.Itoa(s.Config.HTTP.Port))
 
 	mux := http.NewServeMux()
 
 	rh := resize.NewResizeHandler(s.service)
-	mux.HandleFunc("GET /fill/{width}/{height}/{url...}", rh.Resize)
+	mux.Handle("GET /fill/{width}/{height}/{url...}", s.limited("fill", s.authenticated(http.HandlerFunc(rh.Resize))))
+
+	hh := health.NewHealthHandler(
+		health.Check{Name: "draining", Fn: health.NotDraining(s.draining.Load)},
+		health.Check{Name: "cache_writable", Fn: health.DirWritable(s.Config.Cache.Path)},
+		health.Check{Name: "disk_space", Fn: health.FreeSpace(s.Config.Cache.Path, s.Config.Health.MinFreeBytes)},
+	)
+	mux.Handle("GET /healthz", s.limited("healthz", http.HandlerFunc(hh.Live)))
+	mux.Handle("GET /readyz", s.limited("readyz", http.HandlerFunc(hh.Ready)))
+
+	if s.apiKeys != nil {
+		// only the own usage and outside of the quotas, the full report is an admin route
+		uh := usage.NewUsageHandler(s.apiKeys)
+		mux.Handle("GET /usage", s.limited("usage", http.HandlerFunc(uh.KeyUsage)))
+	}
 
-	server := &http.Server{
+	if s.Config.HTTP.AdminPort > 0 {
+		// management routes are reachable only on the admin listener
+		s.admin = s.newAdminServer()
+	} else {
+		mux.Handle("GET /metrics", s.limited("metrics", metrics.Handler()))
+		if s.cacheAdmin != nil && s.Config.HTTP.AdminToken != "" {
+			s.cacheAdminRoutes(mux, s.adminOnly)
+		}
+		if s.apiKeys != nil && s.Config.HTTP.AdminToken != "" {
+			s.usageAdminRoute(mux, s.adminOnly)
+		}
+	}
+
+	var handler http.Handler = recordRoute(mux)
+	if s.clientKey != nil {
+		handler = identifyClient(s.clientKey, handler)
+	}
+
+	s.server = &http.Server{
 		Addr:              addr,
-		Handler:           mux,
+		Handler:           traced(instrument(accessLog(handler))),
 		ReadHeaderTimeout: 10 * time.Second,
 	}
 
-	slog.Info("Starting server at : " + addr)
-	err := server.ListenAndServe()
-	if err != nil {
-		return err
+	if s.Config.HTTP.TLSEnabled() {
+		s.server.Protocols = new(http.Protocols)
+		s.server.Protocols.SetHTTP1(true)
+		s.server.Protocols.SetHTTP2(true)
+
+		if s.Config.HTTP.RedirectPort > 0 {
+			s.redirect = &http.Server{
+				Addr:              net.JoinHostPort(s.Config.HTTP.Host, strconv.Itoa(s.Config.HTTP.RedirectPort)),
+				Handler:           redirectToHTTPS(s.Config.HTTP.Port),
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+		}
+	}
+
+	return s
+}
+
+// UpdateConfig records the reloaded config. Listeners keep their settings until restart.
+func (s *Server) UpdateConfig(cfg *config.Config) {
+	s.current.Store(cfg)
+}
+
+func (s *Server) limited(route string, next http.Handler) http.Handler {
+	limiter, ok := s.rateLimits[route]
+	if !ok {
+		return next
+	}
+
+	return rateLimit(route, limiter, next)
+}
+
+func (s *Server) authenticated(next http.Handler) http.Handler {
+	if s.apiKeys == nil {
+		return next
+	}
+
+	return authenticate(s.apiKeys, next)
+}
+
+func (s *Server) adminOnly(next http.Handler) http.Handler {
+	return bearerAuth(s.Config.HTTP.AdminToken, next)
+}
+
+// Start serves the public, admin and redirect listeners until they are shut down.
+// It returns the first listener error.
+func (s *Server) Start() error {
+	if s.Config.HTTP.TLSEnabled() {
+		reloader, err := newCertReloader(s.Config.HTTP.TLSCertFile, s.Config.HTTP.TLSKeyFile)
+		if err != nil {
+			return err
+		}
+		s.server.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.GetCertificate,
+		}
+		go reloader.watch(s.Config.HTTP.TLSReloadInterval, s.stop)
+	}
+
+	servers := []*http.Server{s.server}
+	for _, srv := range []*http.Server{s.admin, s.redirect} {
+		if srv != nil {
+			servers = append(servers, srv)
+		}
+	}
+
+	errs := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func() {
+			var err error
+			if srv.TLSConfig != nil {
+				slog.Info("Starting tls server at : " + srv.Addr)
+				err = srv.ListenAndServeTLS("", "")
+			} else {
+				slog.Info("Starting server at : " + srv.Addr)
+				err = srv.ListenAndServe()
+			}
+			if errors.Is(err, http.ErrServerClosed) {
+				err = nil
+			}
+			errs <- err
+		}()
+	}
+
+	for range servers {
+		if err := <-errs; err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
+
+// Shutdown marks the server as not ready, waits for the drain delay so load balancers
+// stop sending traffic, then gracefully closes connections.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.draining.Store(true)
+	slog.Info("Server is draining")
+
+	if s.Config.HTTP.DrainDelay > 0 {
+		timer := time.NewTimer(s.Config.HTTP.DrainDelay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}
+
+	s.stopOnce.Do(func() { close(s.stop) })
+	err := s.server.Shutdown(ctx)
+	for _, srv := range []*http.Server{s.admin, s.redirect} {
+		if srv != nil {
+			err = errors.Join(err, srv.Shutdown(ctx))
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"image"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/esavich/otus_project/internal/apikey"
+	"github.com/esavich/otus_project/internal/config"
+	"github.com/esavich/otus_project/internal/diskcache"
+	"github.com/esavich/otus_project/internal/metrics"
+	"github.com/esavich/otus_project/internal/ratelimit"
+)
+
+func newTestConfig(t *testing.T) *config.Config {
+	t.Helper()
+
+	cfg := &config.Config{}
+	cfg.Cache.Path = t.TempDir()
+	cfg.HTTP.Host = "127.0.0.1"
+	cfg.HTTP.AdminHost = "127.0.0.1"
+	cfg.HTTP.AdminToken = "secret"
+
+	return cfg
+}
+
+func serve(h http.Handler, method, target, token string) int {
+	r := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+
+	return rec.Code
+}
+
+func TestServer_AdminListener(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.HTTP.AdminPort = 9090
+	dc, err := diskcache.NewDiskCacheWrapper(10, cfg.Cache.Path)
+	require.NoError(t, err)
+
+	s := NewServer(cfg, nil, WithCacheAdmin(dc))
+	require.NotNil(t, s.admin)
+
+	public := s.server.Handler
+	require.Equal(t, http.StatusOK, serve(public, http.MethodGet, "/healthz", ""))
+	require.Equal(t, http.StatusNotFound, serve(public, http.MethodGet, "/metrics", ""))
+	require.Equal(t, http.StatusNotFound, serve(public, http.MethodGet, "/admin/cache/stats", "secret"))
+
+	admin := s.admin.Handler
+	require.Equal(t, http.StatusUnauthorized, serve(admin, http.MethodGet, "/metrics", ""))
+	for _, target := range []string{"/metrics", "/debug/pprof/", "/admin/cache/stats", "/admin/config"} {
+		require.Equal(t, http.StatusOK, serve(admin, http.MethodGet, target, "secret"), target)
+	}
+	require.Equal(t, http.StatusOK, serve(admin, http.MethodDelete, "/admin/cache?url=http://a/b.jpg", "secret"))
+}
+
+func TestServer_NoAdminListener(t *testing.T) {
+	cfg := newTestConfig(t)
+	dc, err := diskcache.NewDiskCacheWrapper(10, cfg.Cache.Path)
+	require.NoError(t, err)
+
+	s := NewServer(cfg, nil, WithCacheAdmin(dc))
+	require.Nil(t, s.admin)
+
+	public := s.server.Handler
+	require.Equal(t, http.StatusOK, serve(public, http.MethodGet, "/metrics", ""))
+	require.Equal(t, http.StatusUnauthorized, serve(public, http.MethodGet, "/admin/cache/stats", ""))
+	require.Equal(t, http.StatusOK, serve(public, http.MethodGet, "/admin/cache/stats", "secret"))
+	require.Equal(t, http.StatusNotFound, serve(public, http.MethodGet, "/debug/pprof/", "secret"))
+}
+
+type imageGetter struct{}
+
+func (imageGetter) GetResizedImage(_ context.Context, width, height int, _ string, _ http.Header) (image.Image, error) {
+	return image.NewRGBA(image.Rect(0, 0, width, height)), nil
+}
+
+func TestServer_Route(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	buf := captureLogs(t)
+
+	cfg := newTestConfig(t)
+	limits := map[string]*ratelimit.Limiter{"fill": ratelimit.New(ratelimit.Limit{Rate: 100, Burst: 100})}
+	s := NewServer(cfg, imageGetter{}, WithRateLimits(ratelimit.ClientIP(nil), limits))
+
+	require.Equal(t, http.StatusOK, serve(s.server.Handler, http.MethodGet, "/fill/10/10/example.com/a.jpg", ""))
+
+	const route = "GET /fill/{width}/{height}/{url...}"
+	var names []string
+	for _, span := range spans.Ended() {
+		if span.SpanKind() == trace.SpanKindServer {
+			names = append(names, span.Name())
+		}
+	}
+	require.Equal(t, []string{route}, names)
+
+	lines := logLines(t, buf)
+	require.Equal(t, route, lines[len(lines)-1]["route"])
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), `resizer_http_requests_total{route="`+route+`",status="200"}`)
+}
+
+func TestServer_Usage(t *testing.T) {
+	cfg := newTestConfig(t)
+	acc, err := apikey.NewAccounting(map[string]apikey.Key{
+		"secret-a": {Name: "team-a", RequestsPerDay: 1},
+		"secret-b": {Name: "team-b"},
+	}, filepath.Join(t.TempDir(), "usage.json"))
+	require.NoError(t, err)
+	s := NewServer(cfg, imageGetter{}, WithAPIKeys(acc))
+
+	usage := func(target, key string) (int, map[string]apikey.KeyUsage) {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.Header.Set(apikey.Header, key)
+		r.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, r)
+
+		var report map[string]apikey.KeyUsage
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+		}
+		return rec.Code, report
+	}
+
+	code, _ := usage("/usage", "unknown")
+	require.Equal(t, http.StatusUnauthorized, code)
+
+	// only the own key and the report doesn't use up the quota
+	for range 3 {
+		code, report := usage("/usage", "secret-a")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, map[string]apikey.KeyUsage{"team-a": {
+			Usage:          apikey.Usage{Date: report["team-a"].Date},
+			RequestsPerDay: 1,
+		}}, report)
+	}
+
+	code, report := usage("/admin/usage", "")
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, report, 2)
+}
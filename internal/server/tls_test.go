@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeCert writes a self signed certificate for localhost with the given common name.
+func writeCert(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	return certFile, keyFile
+}
+
+func commonName(t *testing.T, r *certReloader) string {
+	t.Helper()
+
+	cert, err := r.GetCertificate(nil)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, "first")
+
+	r, err := newCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+	require.Equal(t, "first", commonName(t, r))
+
+	// nothing changed
+	require.NoError(t, r.reloadIfChanged())
+	require.Equal(t, "first", commonName(t, r))
+
+	writeCert(t, dir, "second")
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+	require.NoError(t, r.reloadIfChanged())
+	require.Equal(t, "second", commonName(t, r))
+
+	// broken files keep the current certificate
+	require.NoError(t, os.WriteFile(keyFile, []byte("garbage"), 0o600))
+	require.Error(t, r.reload())
+	require.Equal(t, "second", commonName(t, r))
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://example.com:8080/fill/1/1/a.jpg?x=1", nil)
+	redirectToHTTPS(8443).ServeHTTP(rec, r)
+	require.Equal(t, http.StatusPermanentRedirect, rec.Code)
+	require.Equal(t, "https://example.com:8443/fill/1/1/a.jpg?x=1", rec.Header().Get("Location"))
+
+	rec = httptest.NewRecorder()
+	redirectToHTTPS(443).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/healthz", nil))
+	require.Equal(t, "https://example.com/healthz", rec.Header().Get("Location"))
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestServer_TLS(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.HTTP.Port = freePort(t)
+	cfg.HTTP.TLSCertFile, cfg.HTTP.TLSKeyFile = writeCert(t, t.TempDir(), "test")
+
+	s := NewServer(cfg, nil)
+	errs := make(chan error, 1)
+	go func() { errs <- s.Start() }()
+	t.Cleanup(func() {
+		require.NoError(t, s.Shutdown(context.Background()))
+		require.NoError(t, <-errs)
+	})
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, //nolint:gosec
+		ForceAttemptHTTP2: true,
+	}}
+	url := "https://127.0.0.1:" + strconv.Itoa(cfg.HTTP.Port) + "/healthz"
+
+	var resp *http.Response
+	require.Eventually(t, func() bool {
+		var err error
+		resp, err = client.Get(url) //nolint:noctx
+		return err == nil
+	}, 2*time.Second, 20*time.Millisecond)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, 2, resp.ProtoMajor)
+}
@@ -1,57 +1,124 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"log/slog"
 	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/esavich/otus_project/internal/cachecontrol"
+	"github.com/esavich/otus_project/internal/logger"
+	"github.com/esavich/otus_project/internal/metrics"
+	"github.com/esavich/otus_project/internal/ratelimit"
 )
 
+var tracer = otel.Tracer("github.com/esavich/otus_project/internal/service")
+
 type disckCache interface {
-	Set(key string, data image.Image) error
-	Get(key string) (image.Image, bool)
+	Set(ctx context.Context, key, source string, data image.Image) error
+	// SetPrivate stores an image of a credentialed request, it is never persisted or exported.
+	SetPrivate(ctx context.Context, key, source string, data image.Image) error
+	Get(ctx context.Context, key string) (image.Image, bool)
 }
 type CachedImageService struct {
-	cache disckCache
-	is    ImageGetter
+	*policyHolder
+	cache       disckCache
+	is          ImageGetter
+	missLimiter limiter
 }
 
-func NewCachedImageService(is ImageGetter, dc disckCache) *CachedImageService {
+func NewCachedImageService(is ImageGetter, dc disckCache, opts ...Option) *CachedImageService {
+	o := newOptions(opts)
 	return &CachedImageService{
-		is:    is,
-		cache: dc,
+		policyHolder: newPolicyHolder(o.policy),
+		is:           is,
+		cache:        dc,
+		missLimiter:  o.missLimiter,
 	}
 }
 
 func (svc *CachedImageService) GetResizedImage(
+	ctx context.Context,
 	width, height int,
 	imgURL string,
 	header http.Header,
 ) (image.Image, error) {
-	key := fmt.Sprintf("%d-%d-%s", width, height, imgURL)
+	ctx, span := tracer.Start(ctx, "CachedImageService.GetResizedImage")
+	defer span.End()
+	log := logger.FromContext(ctx)
+
+	base := fmt.Sprintf("%d-%d-%s", width, height, imgURL)
+	key, cacheable := svc.cachePolicy().key(base, header)
+	if !cacheable {
+		log.Info("Request has credentials, bypassing cache")
+		span.SetAttributes(attribute.Bool("cache.bypass", true))
+		logger.AddAccessAttrs(ctx, slog.String("cache", "bypass"))
+		if err := svc.allowMiss(ctx); err != nil {
+			return nil, err
+		}
+		return svc.is.GetResizedImage(ctx, width, height, imgURL, header)
+	}
 
-	slog.Debug("Cache key:" + key)
+	log.Debug("Cache key:" + key)
 
-	slog.Info(fmt.Sprintf("Trying to get image from cache: %s", key))
+	log.Info(fmt.Sprintf("Trying to get image from cache: %s", key))
 
-	if cachedImg, found := svc.cache.Get(key); found {
-		slog.Info(fmt.Sprintf("Cache hit: %s", key))
+	if cachedImg, found := svc.cache.Get(ctx, key); found {
+		log.Info(fmt.Sprintf("Cache hit: %s", key))
+		span.SetAttributes(attribute.Bool("cache.hit", true))
+		logger.AddAccessAttrs(ctx, slog.String("cache", "hit"))
 		return cachedImg, nil
 	}
 
-	slog.Info("Cache miss, downloading image")
+	log.Info("Cache miss, downloading image")
+	span.SetAttributes(attribute.Bool("cache.hit", false))
+	logger.AddAccessAttrs(ctx, slog.String("cache", "miss"))
+	if err := svc.allowMiss(ctx); err != nil {
+		return nil, err
+	}
 
-	resizedImage, err := svc.is.GetResizedImage(width, height, imgURL, header)
+	ctx, rec := cachecontrol.WithRecorder(ctx)
+	resizedImage, err := svc.is.GetResizedImage(ctx, width, height, imgURL, header)
 	if err != nil {
 		return nil, err
 	}
 
+	if !rec.Directives().Shareable() {
+		log.Info(fmt.Sprintf("Origin response is not cacheable, skip cache set: %s", key))
+		return resizedImage, nil
+	}
+
 	// cache the resized image
-	err = svc.cache.Set(key, resizedImage)
+	if key == base {
+		err = svc.cache.Set(ctx, key, imgURL, resizedImage)
+	} else {
+		err = svc.cache.SetPrivate(ctx, key, imgURL, resizedImage)
+	}
 	if err != nil {
 		return nil, err
 	}
-	slog.Info(fmt.Sprintf("Cache set: %s", key))
+	log.Info(fmt.Sprintf("Cache set: %s", key))
 
 	return resizedImage, nil
 }
+
+// allowMiss applies the lower rate limit for requests that need a download and resize.
+func (svc *CachedImageService) allowMiss(ctx context.Context) error {
+	if svc.missLimiter == nil || ratelimit.Exempt(ctx) {
+		return nil
+	}
+
+	client := ratelimit.ClientFromContext(ctx)
+	if ok, wait := svc.missLimiter.Allow(client); !ok {
+		logger.FromContext(ctx).Info("Cache miss rate limit exceeded", slog.String("client", client))
+		logger.AddAccessAttrs(ctx, slog.String("rate_limited", "miss"))
+		metrics.ObserveRateLimited("miss")
+		return &ratelimit.Error{RetryAfter: wait}
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"image"
 	"net/http"
@@ -8,13 +9,16 @@ import (
 
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+
+	"github.com/esavich/otus_project/internal/cachecontrol"
+	"github.com/esavich/otus_project/internal/ratelimit"
 )
 
 type MockCache struct {
 	mock.Mock
 }
 
-func (m *MockCache) Get(key string) (image.Image, bool) {
+func (m *MockCache) Get(_ context.Context, key string) (image.Image, bool) {
 	args := m.Called(key)
 	img := args.Get(0)
 	if img == nil {
@@ -23,8 +27,13 @@ func (m *MockCache) Get(key string) (image.Image, bool) {
 	return img.(image.Image), args.Bool(1)
 }
 
-func (m *MockCache) Set(key string, img image.Image) error {
-	args := m.Called(key, img)
+func (m *MockCache) Set(_ context.Context, key, source string, img image.Image) error {
+	args := m.Called(key, source, img)
+	return args.Error(0)
+}
+
+func (m *MockCache) SetPrivate(_ context.Context, key, source string, img image.Image) error {
+	args := m.Called(key, source, img)
 	return args.Error(0)
 }
 
@@ -32,7 +41,12 @@ type MockImageGetter struct {
 	mock.Mock
 }
 
-func (m *MockImageGetter) GetResizedImage(width, height int, imgURL string, header http.Header) (image.Image, error) {
+func (m *MockImageGetter) GetResizedImage(
+	_ context.Context,
+	width, height int,
+	imgURL string,
+	header http.Header,
+) (image.Image, error) {
 	args := m.Called(width, height, imgURL, header)
 	img := args.Get(0)
 	if img == nil {
@@ -52,7 +66,7 @@ func TestCachedImageService_GetResizedImage_CacheHit(t *testing.T) {
 
 	cache.On("Get", key).Return(cachedImg, true)
 
-	result, err := svc.GetResizedImage(50, 60, testImgURL, headers)
+	result, err := svc.GetResizedImage(context.Background(), 50, 60, testImgURL, headers)
 	require.NoError(t, err)
 	require.Equal(t, cachedImg, result)
 
@@ -71,15 +85,15 @@ func TestCachedImageService_GetResizedImage_CacheMiss_Success(t *testing.T) {
 
 	cache.On("Get", key).Return(nil, false)
 	imageGetter.On("GetResizedImage", 50, 60, testImgURL, headers).Return(resizedImg, nil)
-	cache.On("Set", key, resizedImg).Return(nil)
+	cache.On("Set", key, testImgURL, resizedImg).Return(nil)
 
-	result, err := svc.GetResizedImage(50, 60, testImgURL, headers)
+	result, err := svc.GetResizedImage(context.Background(), 50, 60, testImgURL, headers)
 	require.NoError(t, err)
 	require.Equal(t, resizedImg, result)
 
 	cache.AssertCalled(t, "Get", key)
 	imageGetter.AssertCalled(t, "GetResizedImage", 50, 60, testImgURL, headers)
-	cache.AssertCalled(t, "Set", key, resizedImg)
+	cache.AssertCalled(t, "Set", key, testImgURL, resizedImg)
 }
 
 func TestCachedImageService_GetResizedImage_CacheMiss_ExternalError(t *testing.T) {
@@ -94,14 +108,14 @@ func TestCachedImageService_GetResizedImage_CacheMiss_ExternalError(t *testing.T
 	cache.On("Get", key).Return(nil, false)
 	imageGetter.On("GetResizedImage", 50, 60, imgURL, headers).Return(nil, errors.New("external error"))
 
-	result, err := svc.GetResizedImage(50, 60, imgURL, headers)
+	result, err := svc.GetResizedImage(context.Background(), 50, 60, imgURL, headers)
 	require.Error(t, err)
 	require.ErrorContains(t, err, "external error")
 	require.Nil(t, result)
 
 	cache.AssertCalled(t, "Get", key)
 	imageGetter.AssertCalled(t, "GetResizedImage", 50, 60, imgURL, headers)
-	cache.AssertNotCalled(t, "Set", mock.Anything, mock.Anything)
+	cache.AssertNotCalled(t, "Set", mock.Anything, mock.Anything, mock.Anything)
 }
 
 func TestCachedImageService_GetResizedImage_CacheMiss_CacheSetError(t *testing.T) {
@@ -115,14 +129,160 @@ func TestCachedImageService_GetResizedImage_CacheMiss_CacheSetError(t *testing.T
 
 	cache.On("Get", key).Return(nil, false)
 	imageGetter.On("GetResizedImage", 50, 60, testImgURL, headers).Return(resizedImg, nil)
-	cache.On("Set", key, resizedImg).Return(errors.New("cache set error"))
+	cache.On("Set", key, testImgURL, resizedImg).Return(errors.New("cache set error"))
 
-	result, err := svc.GetResizedImage(50, 60, testImgURL, headers)
+	result, err := svc.GetResizedImage(context.Background(), 50, 60, testImgURL, headers)
 	require.Error(t, err)
 	require.ErrorContains(t, err, "cache set error")
 	require.Nil(t, result)
 
 	cache.AssertCalled(t, "Get", key)
 	imageGetter.AssertCalled(t, "GetResizedImage", 50, 60, testImgURL, headers)
-	cache.AssertCalled(t, "Set", key, resizedImg)
+	cache.AssertCalled(t, "Set", key, testImgURL, resizedImg)
+}
+
+type cacheControlGetter struct {
+	img          image.Image
+	cacheControl string
+}
+
+func (g cacheControlGetter) GetResizedImage(
+	ctx context.Context,
+	_, _ int,
+	_ string,
+	_ http.Header,
+) (image.Image, error) {
+	cachecontrol.Record(ctx, g.cacheControl)
+	return g.img, nil
+}
+
+func TestCachedImageService_GetResizedImage_PartitionByCredentials(t *testing.T) {
+	cache := new(MockCache)
+	imageGetter := new(MockImageGetter)
+	svc := NewCachedImageService(imageGetter, cache, WithCachePolicy(CachePolicy{VaryHeaders: []string{"Cookie"}}))
+
+	alice := http.Header{"Cookie": []string{"session=alice"}}
+	bob := http.Header{"Cookie": []string{"session=bob"}}
+	img := image.NewRGBA(image.Rect(0, 0, 50, 60))
+
+	cache.On("Get", mock.Anything).Return(nil, false)
+	cache.On("Set", mock.Anything, testImgURL, img).Return(nil)
+	cache.On("SetPrivate", mock.Anything, testImgURL, img).Return(nil)
+	imageGetter.On("GetResizedImage", 50, 60, testImgURL, mock.Anything).Return(img, nil)
+
+	_, err := svc.GetResizedImage(context.Background(), 50, 60, testImgURL, alice)
+	require.NoError(t, err)
+	_, err = svc.GetResizedImage(context.Background(), 50, 60, testImgURL, bob)
+	require.NoError(t, err)
+	_, err = svc.GetResizedImage(context.Background(), 50, 60, testImgURL, http.Header{})
+	require.NoError(t, err)
+
+	require.Len(t, cache.Calls, 6)
+	aliceKey := cache.Calls[0].Arguments.String(0)
+	bobKey := cache.Calls[2].Arguments.String(0)
+	anonKey := cache.Calls[4].Arguments.String(0)
+	require.NotEqual(t, aliceKey, bobKey)
+	require.Equal(t, "50-60-"+testImgURL, anonKey)
+	require.NotContains(t, aliceKey, "alice")
+	// only images of anonymous requests may be persisted and exported
+	require.Equal(t, "SetPrivate", cache.Calls[1].Method)
+	require.Equal(t, "SetPrivate", cache.Calls[3].Method)
+	require.Equal(t, "Set", cache.Calls[5].Method)
+}
+
+func TestCachedImageService_GetResizedImage_BypassCredentials(t *testing.T) {
+	cache := new(MockCache)
+	imageGetter := new(MockImageGetter)
+	svc := NewCachedImageService(imageGetter, cache, WithCachePolicy(CachePolicy{
+		VaryHeaders:       []string{"Authorization"},
+		BypassCredentials: true,
+	}))
+
+	headers := http.Header{"Authorization": []string{"Bearer secret"}}
+	img := image.NewRGBA(image.Rect(0, 0, 50, 60))
+	imageGetter.On("GetResizedImage", 50, 60, testImgURL, headers).Return(img, nil)
+
+	result, err := svc.GetResizedImage(context.Background(), 50, 60, testImgURL, headers)
+	require.NoError(t, err)
+	require.Equal(t, img, result)
+
+	cache.AssertNotCalled(t, "Get", mock.Anything)
+	cache.AssertNotCalled(t, "Set", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCachedImageService_GetResizedImage_PrivateResponse(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 50, 60))
+	key := "50-60-" + testImgURL
+
+	for _, cacheControl := range []string{"private", "no-store", "max-age=0, no-cache"} {
+		t.Run(cacheControl, func(t *testing.T) {
+			cache := new(MockCache)
+			svc := NewCachedImageService(cacheControlGetter{img: img, cacheControl: cacheControl}, cache)
+			cache.On("Get", key).Return(nil, false)
+
+			result, err := svc.GetResizedImage(context.Background(), 50, 60, testImgURL, http.Header{})
+			require.NoError(t, err)
+			require.Equal(t, img, result)
+			cache.AssertNotCalled(t, "Set", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+
+	cache := new(MockCache)
+	svc := NewCachedImageService(cacheControlGetter{img: img, cacheControl: "public, max-age=60"}, cache)
+	cache.On("Get", key).Return(nil, false)
+	cache.On("Set", key, testImgURL, img).Return(nil)
+
+	_, err := svc.GetResizedImage(context.Background(), 50, 60, testImgURL, http.Header{})
+	require.NoError(t, err)
+	cache.AssertCalled(t, "Set", key, testImgURL, img)
+}
+
+func TestCachedImageService_GetResizedImage_MissLimit(t *testing.T) {
+	cache := new(MockCache)
+	imageGetter := new(MockImageGetter)
+	limiter := ratelimit.New(ratelimit.Limit{Rate: 1, Burst: 1})
+	svc := NewCachedImageService(imageGetter, cache, WithMissLimiter(limiter))
+
+	headers := http.Header{}
+	key := "50-60-" + testImgURL
+	img := image.NewRGBA(image.Rect(0, 0, 50, 60))
+	cache.On("Get", key).Return(nil, false).Twice()
+	cache.On("Set", key, testImgURL, img).Return(nil)
+	imageGetter.On("GetResizedImage", 50, 60, testImgURL, headers).Return(img, nil)
+
+	ctx := ratelimit.WithClient(context.Background(), "1.2.3.4")
+	_, err := svc.GetResizedImage(ctx, 50, 60, testImgURL, headers)
+	require.NoError(t, err)
+
+	_, err = svc.GetResizedImage(ctx, 50, 60, testImgURL, headers)
+	var limitErr *ratelimit.Error
+	require.ErrorAs(t, err, &limitErr)
+	imageGetter.AssertNumberOfCalls(t, "GetResizedImage", 1)
+
+	// internal work like warm-up is not limited
+	cache.On("Get", key).Return(nil, false).Once()
+	_, err = svc.GetResizedImage(ratelimit.WithoutLimits(ctx), 50, 60, testImgURL, headers)
+	require.NoError(t, err)
+	imageGetter.AssertNumberOfCalls(t, "GetResizedImage", 2)
+
+	// hits are not limited by the miss limit
+	cache.On("Get", key).Return(img, true)
+	result, err := svc.GetResizedImage(ctx, 50, 60, testImgURL, headers)
+	require.NoError(t, err)
+	require.Equal(t, img, result)
+}
+
+func TestCachedImageService_SetCachePolicy(t *testing.T) {
+	cache := new(MockCache)
+	imageGetter := new(MockImageGetter)
+	svc := NewCachedImageService(imageGetter, cache)
+
+	headers := http.Header{"Cookie": []string{"session=alice"}}
+	img := image.NewRGBA(image.Rect(0, 0, 50, 60))
+	imageGetter.On("GetResizedImage", 50, 60, testImgURL, headers).Return(img, nil)
+
+	svc.SetCachePolicy(CachePolicy{VaryHeaders: []string{"Cookie"}, BypassCredentials: true})
+	_, err := svc.GetResizedImage(context.Background(), 50, 60, testImgURL, headers)
+	require.NoError(t, err)
+	cache.AssertNotCalled(t, "Get", mock.Anything)
 }
@@ -1,50 +1,70 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"log/slog"
 	"net/http"
+
+	"github.com/esavich/otus_project/internal/logger"
 )
 
 type ImageGetter interface {
-	GetResizedImage(width, height int, imgURL string, header http.Header) (image.Image, error)
+	GetResizedImage(ctx context.Context, width, height int, imgURL string, header http.Header) (image.Image, error)
 }
 
 type resizer interface {
-	ResizeImg(img image.Image, w int, h int) image.Image
+	ResizeImg(ctx context.Context, img image.Image, w int, h int) image.Image
 }
 
 type downloader interface {
-	Download(imgURL string, header http.Header) (image.Image, error)
+	Download(ctx context.Context, imgURL string, header http.Header) (image.Image, error)
+}
+
+// executor runs cpu bound work, it may refuse the job when overloaded.
+type executor interface {
+	Do(fn func()) error
 }
 
 type SimpleImageService struct {
 	dl downloader
 	rz resizer
+	ex executor
 }
 
-func NewSimpleImageService(dl downloader, rz resizer) *SimpleImageService {
+func NewSimpleImageService(dl downloader, rz resizer, ex executor) *SimpleImageService {
 	return &SimpleImageService{
 		dl: dl,
 		rz: rz,
+		ex: ex,
 	}
 }
 
 func (svc *SimpleImageService) GetResizedImage(
+	ctx context.Context,
 	width, height int,
 	imgURL string,
 	header http.Header,
 ) (image.Image, error) {
-	img, err := svc.dl.Download(imgURL, header)
+	log := logger.FromContext(ctx)
+	img, err := svc.dl.Download(ctx, imgURL, header)
 	if err != nil {
 		err = fmt.Errorf("failed to download image: %w", err)
-		slog.Error(err.Error())
+		log.Error(err.Error())
+		return nil, err
+	}
+	log.Info("Image downloaded")
+	log.Info("Resizing image", slog.Int("width", width), slog.Int("height", height))
+	var resized image.Image
+	err = svc.ex.Do(func() {
+		resized = svc.rz.ResizeImg(ctx, img, width, height)
+	})
+	if err != nil {
+		err = fmt.Errorf("failed to resize image: %w", err)
+		log.Error(err.Error())
 		return nil, err
 	}
-	slog.Info("Image downloaded")
-	slog.Info("Resizing image", slog.Int("width", width), slog.Int("height", height))
-	resized := svc.rz.ResizeImg(img, width, height)
 
 	return resized, nil
 }
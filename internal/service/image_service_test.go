@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"image"
 	"net/http"
@@ -14,7 +15,7 @@ type MockDownloader struct {
 	mock.Mock
 }
 
-func (m *MockDownloader) Download(imgURL string, header http.Header) (image.Image, error) {
+func (m *MockDownloader) Download(_ context.Context, imgURL string, header http.Header) (image.Image, error) {
 	args := m.Called(imgURL, header)
 
 	img := args.Get(0)
@@ -28,18 +29,33 @@ type MockResizer struct {
 	mock.Mock
 }
 
-func (m *MockResizer) ResizeImg(img image.Image, w int, h int) image.Image {
+func (m *MockResizer) ResizeImg(_ context.Context, img image.Image, w int, h int) image.Image {
 	args := m.Called(img, w, h)
 	return args.Get(0).(image.Image)
 }
 
+type inlineExecutor struct{}
+
+func (inlineExecutor) Do(fn func()) error {
+	fn()
+	return nil
+}
+
+type busyExecutor struct{}
+
+func (busyExecutor) Do(func()) error {
+	return errBusy
+}
+
+var errBusy = errors.New("busy")
+
 const testImgURL = "http://example.com/image.jpg"
 
 func TestSimpleImageService_GetResizedImage_Success(t *testing.T) {
 	mockDownloader := new(MockDownloader)
 	mockResizer := new(MockResizer)
 
-	service := NewSimpleImageService(mockDownloader, mockResizer)
+	service := NewSimpleImageService(mockDownloader, mockResizer, inlineExecutor{})
 
 	headers := http.Header{"Authorization": []string{"Bearer token"}}
 
@@ -51,7 +67,7 @@ func TestSimpleImageService_GetResizedImage_Success(t *testing.T) {
 	mockDownloader.On("Download", testImgURL, headers).Return(testImage, nil)
 	mockResizer.On("ResizeImg", testImage, 50, 60).Return(resizedImage)
 
-	result, err := service.GetResizedImage(50, 60, testImgURL, headers)
+	result, err := service.GetResizedImage(context.Background(), 50, 60, testImgURL, headers)
 
 	require.NoError(t, err)
 	require.Equal(t, resizedImage, result)
@@ -65,13 +81,13 @@ func TestSimpleImageService_GetResizedImage_DownloadNil(t *testing.T) {
 	mockDownloader := new(MockDownloader)
 	mockResizer := new(MockResizer)
 
-	service := NewSimpleImageService(mockDownloader, mockResizer)
+	service := NewSimpleImageService(mockDownloader, mockResizer, inlineExecutor{})
 
 	headers := http.Header{"Authorization": []string{"Bearer token"}}
 
 	mockDownloader.On("Download", testImgURL, headers).Return(nil, errors.New("download error"))
 
-	result, err := service.GetResizedImage(50, 60, testImgURL, headers)
+	result, err := service.GetResizedImage(context.Background(), 50, 60, testImgURL, headers)
 
 	require.Error(t, err)
 	require.Nil(t, result)
@@ -79,3 +95,20 @@ func TestSimpleImageService_GetResizedImage_DownloadNil(t *testing.T) {
 	mockDownloader.AssertCalled(t, "Download", testImgURL, headers)
 	mockResizer.AssertNotCalled(t, "ResizeImg")
 }
+
+func TestSimpleImageService_GetResizedImage_ExecutorBusy(t *testing.T) {
+	mockDownloader := new(MockDownloader)
+	mockResizer := new(MockResizer)
+
+	service := NewSimpleImageService(mockDownloader, mockResizer, busyExecutor{})
+
+	headers := http.Header{}
+	testImage := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	mockDownloader.On("Download", testImgURL, headers).Return(testImage, nil)
+
+	result, err := service.GetResizedImage(context.Background(), 50, 60, testImgURL, headers)
+
+	require.ErrorIs(t, err, errBusy)
+	require.Nil(t, result)
+	mockResizer.AssertNotCalled(t, "ResizeImg")
+}
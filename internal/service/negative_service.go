@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/esavich/otus_project/internal/cache"
+	"github.com/esavich/otus_project/internal/logger"
+)
+
+// failureShards lets concurrent requests for different urls check the cache without waiting for each other.
+const failureShards = 16
+
+type failure struct {
+	source  string
+	err     error
+	expires time.Time
+}
+
+// NegativeCachedImageService remembers upstream failures by source url for a short time,
+// so a broken url does not trigger a download on every request.
+type NegativeCachedImageService struct {
+	*policyHolder
+	is        ImageGetter
+	failures  cache.Cache[string, failure]
+	ttl       time.Duration
+	cacheable func(err error) bool
+	now       func() time.Time
+}
+
+// NewNegativeCachedImageService creates the service, cacheable decides which errors are remembered.
+func NewNegativeCachedImageService(
+	is ImageGetter,
+	capacity int,
+	ttl time.Duration,
+	cacheable func(err error) bool,
+	opts ...Option,
+) *NegativeCachedImageService {
+	o := newOptions(opts)
+	return &NegativeCachedImageService{
+		policyHolder: newPolicyHolder(o.policy),
+		is:           is,
+		failures:     cache.NewSharded[string, failure](capacity, failureShards),
+		ttl:          ttl,
+		cacheable:    cacheable,
+		now:          time.Now,
+	}
+}
+
+func (svc *NegativeCachedImageService) GetResizedImage(
+	ctx context.Context,
+	width, height int,
+	imgURL string,
+	header http.Header,
+) (image.Image, error) {
+	log := logger.FromContext(ctx)
+	key, cacheable := svc.cachePolicy().key(imgURL, header)
+	if !cacheable {
+		return svc.is.GetResizedImage(ctx, width, height, imgURL, header)
+	}
+
+	if f, found := svc.failures.Get(key); found {
+		if svc.now().Before(f.expires) {
+			log.Info(fmt.Sprintf("Negative cache hit: %s", imgURL))
+			logger.AddAccessAttrs(ctx, slog.String("negative_cache", "hit"))
+			return nil, f.err
+		}
+	}
+
+	img, err := svc.is.GetResizedImage(ctx, width, height, imgURL, header)
+	if err != nil {
+		if svc.cacheable(err) {
+			svc.failures.Set(key, failure{source: imgURL, err: err, expires: svc.now().Add(svc.ttl)}, nil)
+			log.Info(fmt.Sprintf("Negative cache set: %s", imgURL))
+		}
+		return nil, err
+	}
+
+	return img, nil
+}
+
+// Forget drops remembered failures of the source urls matching match, so they are downloaded again.
+// It returns the number of dropped failures.
+func (svc *NegativeCachedImageService) Forget(match func(source string) bool) int {
+	var keys []string
+	svc.failures.Range(func(key string, f failure) bool {
+		if match(f.source) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+
+	forgotten := 0
+	for _, key := range keys {
+		if svc.failures.Remove(key) {
+			forgotten++
+		}
+	}
+
+	return forgotten
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+var errPermanent = errors.New("permanent error")
+
+func isPermanent(err error) bool {
+	return errors.Is(err, errPermanent)
+}
+
+func TestNegativeCachedImageService_PermanentErrorCached(t *testing.T) {
+	imageGetter := new(MockImageGetter)
+	svc := NewNegativeCachedImageService(imageGetter, 10, time.Minute, isPermanent)
+
+	headers := http.Header{}
+	notFound := fmt.Errorf("failed to download image: %w", errPermanent)
+	imageGetter.On("GetResizedImage", 50, 60, testImgURL, headers).Return(nil, notFound).Once()
+
+	_, err := svc.GetResizedImage(context.Background(), 50, 60, testImgURL, headers)
+	require.ErrorIs(t, err, notFound)
+
+	// other size of the same url must be answered from the negative cache
+	_, err = svc.GetResizedImage(context.Background(), 100, 100, testImgURL, headers)
+	require.ErrorIs(t, err, notFound)
+
+	imageGetter.AssertNumberOfCalls(t, "GetResizedImage", 1)
+}
+
+func TestNegativeCachedImageService_Expired(t *testing.T) {
+	imageGetter := new(MockImageGetter)
+	svc := NewNegativeCachedImageService(imageGetter, 10, time.Minute, isPermanent)
+	now := time.Now()
+	svc.now = func() time.Time { return now }
+
+	headers := http.Header{}
+	decodeErr := fmt.Errorf("%w: broken image", errPermanent)
+	resizedImg := image.NewRGBA(image.Rect(0, 0, 50, 60))
+	imageGetter.On("GetResizedImage", 50, 60, testImgURL, headers).Return(nil, decodeErr).Once()
+	imageGetter.On("GetResizedImage", 50, 60, testImgURL, headers).Return(resizedImg, nil).Once()
+
+	_, err := svc.GetResizedImage(context.Background(), 50, 60, testImgURL, headers)
+	require.ErrorIs(t, err, errPermanent)
+
+	now = now.Add(2 * time.Minute)
+
+	result, err := svc.GetResizedImage(context.Background(), 50, 60, testImgURL, headers)
+	require.NoError(t, err)
+	require.Equal(t, resizedImg, result)
+	imageGetter.AssertNumberOfCalls(t, "GetResizedImage", 2)
+}
+
+func TestNegativeCachedImageService_TransientNotCached(t *testing.T) {
+	imageGetter := new(MockImageGetter)
+	svc := NewNegativeCachedImageService(imageGetter, 10, time.Minute, isPermanent)
+
+	headers := http.Header{}
+	imageGetter.On("GetResizedImage", 50, 60, testImgURL, headers).Return(nil, context.DeadlineExceeded)
+
+	for i := 0; i < 3; i++ {
+		_, err := svc.GetResizedImage(context.Background(), 50, 60, testImgURL, headers)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	}
+	imageGetter.AssertNumberOfCalls(t, "GetResizedImage", 3)
+}
+
+func TestNegativeCachedImageService_TransientCachedWhenEnabled(t *testing.T) {
+	imageGetter := new(MockImageGetter)
+	svc := NewNegativeCachedImageService(imageGetter, 10, time.Minute, func(error) bool { return true })
+
+	headers := http.Header{}
+	imageGetter.On("GetResizedImage", 50, 60, testImgURL, headers).Return(nil, errors.New("connection refused"))
+
+	for i := 0; i < 3; i++ {
+		_, err := svc.GetResizedImage(context.Background(), 50, 60, testImgURL, headers)
+		require.ErrorContains(t, err, "connection refused")
+	}
+	imageGetter.AssertNumberOfCalls(t, "GetResizedImage", 1)
+}
+
+func TestNegativeCachedImageService_Forget(t *testing.T) {
+	imageGetter := new(MockImageGetter)
+	svc := NewNegativeCachedImageService(imageGetter, 10, time.Minute, isPermanent,
+		WithCachePolicy(CachePolicy{VaryHeaders: []string{"Cookie"}}))
+
+	notFound := fmt.Errorf("failed to download image: %w", errPermanent)
+	imageGetter.On("GetResizedImage", 50, 60, testImgURL, mock.Anything).Return(nil, notFound)
+	// the anonymous and the credentialed failure of the url are both forgotten
+	for _, headers := range []http.Header{{}, {"Cookie": []string{"session=alice"}}} {
+		_, err := svc.GetResizedImage(context.Background(), 50, 60, testImgURL, headers)
+		require.ErrorIs(t, err, notFound)
+	}
+
+	require.Zero(t, svc.Forget(func(source string) bool { return source == "http://other.example.com/a.jpg" }))
+	require.Equal(t, 2, svc.Forget(func(source string) bool { return source == testImgURL }))
+
+	_, err := svc.GetResizedImage(context.Background(), 50, 60, testImgURL, http.Header{})
+	require.ErrorIs(t, err, notFound)
+	imageGetter.AssertNumberOfCalls(t, "GetResizedImage", 3)
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// CachePolicy decides how requests with forwarded credentials use the shared cache.
+type CachePolicy struct {
+	// VaryHeaders are request headers forwarded to origins that can change the response,
+	// e.g. Authorization and Cookie. Requests with them get their own cache partition.
+	VaryHeaders []string
+	// BypassCredentials disables caching for requests with any of VaryHeaders instead of partitioning.
+	BypassCredentials bool
+}
+
+type Option func(*options)
+
+type options struct {
+	policy      CachePolicy
+	missLimiter limiter
+}
+
+// limiter is a per client rate limiter.
+type limiter interface {
+	Allow(key string) (bool, time.Duration)
+}
+
+// WithCachePolicy sets the cache policy for requests with credentials.
+func WithCachePolicy(policy CachePolicy) Option {
+	return func(o *options) {
+		o.policy = policy
+	}
+}
+
+// WithMissLimiter limits requests that miss the cache and need a download and resize,
+// clients are identified by ratelimit.ClientFromContext.
+func WithMissLimiter(l limiter) Option {
+	return func(o *options) {
+		o.missLimiter = l
+	}
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// partitionKey keys partition hashes. It is new on every start, so a partition can't be computed
+// from guessed credentials outside the process, and entries of credentialed requests live only as long as it.
+var partitionKey = newPartitionKey()
+
+func newPartitionKey() []byte {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+
+	return key
+}
+
+// partition returns the cache partition of the request, empty for anonymous requests.
+func (p CachePolicy) partition(header http.Header) string {
+	var parts []string
+	for _, name := range p.VaryHeaders {
+		if values := header.Values(name); len(values) > 0 {
+			parts = append(parts, http.CanonicalHeaderKey(name)+"="+strings.Join(values, ","))
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	sort.Strings(parts)
+
+	// hash to not keep credentials in cache keys and file index
+	mac := hmac.New(sha256.New, partitionKey)
+	mac.Write([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// policyHolder lets the cache policy change at runtime.
+type policyHolder struct {
+	policy atomic.Pointer[CachePolicy]
+}
+
+func newPolicyHolder(policy CachePolicy) *policyHolder {
+	h := &policyHolder{}
+	h.policy.Store(&policy)
+	return h
+}
+
+// SetCachePolicy replaces the cache policy of a running service.
+func (h *policyHolder) SetCachePolicy(policy CachePolicy) {
+	h.policy.Store(&policy)
+}
+
+func (h *policyHolder) cachePolicy() CachePolicy {
+	return *h.policy.Load()
+}
+
+// key returns the cache key for base and whether the request may use the cache at all.
+// Keys of credentialed requests differ from base.
+func (p CachePolicy) key(base string, header http.Header) (string, bool) {
+	partition := p.partition(header)
+	switch {
+	case partition == "":
+		return base, true
+	case p.BypassCredentials:
+		return "", false
+	default:
+		return base + "|" + partition, true
+	}
+}
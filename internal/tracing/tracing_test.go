@@ -0,0 +1,84 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/esavich/otus_project/internal/config"
+)
+
+func tracingConfig(exporter string, ratio float64) *config.Config {
+	return &config.Config{
+		App: config.AppConf{ServiceName: "resizer-test"},
+		Tracing: config.TracingConf{
+			Exporter:    exporter,
+			Endpoint:    "localhost:4318",
+			Insecure:    true,
+			SampleRatio: ratio,
+		},
+	}
+}
+
+func TestSetup_None(t *testing.T) {
+	prev := otel.GetTracerProvider()
+
+	shutdown, err := Setup(context.Background(), tracingConfig(ExporterNone, 1))
+	require.NoError(t, err)
+	require.Same(t, prev, otel.GetTracerProvider())
+	require.NoError(t, shutdown(context.Background()))
+}
+
+func TestSetup_Stdout(t *testing.T) {
+	var buf bytes.Buffer
+	shutdown, err := Setup(context.Background(), tracingConfig(ExporterStdout, 1), WithWriter(&buf))
+	require.NoError(t, err)
+	require.IsType(t, &sdktrace.TracerProvider{}, otel.GetTracerProvider())
+
+	_, span := otel.Tracer("test").Start(context.Background(), "test-span")
+	require.True(t, span.SpanContext().IsSampled())
+	span.End()
+
+	// spans are batched until shutdown flushes them
+	require.NoError(t, shutdown(context.Background()))
+	require.Contains(t, buf.String(), `"Name":"test-span"`)
+	require.Contains(t, buf.String(), "resizer-test")
+}
+
+func TestSetup_OTLP(t *testing.T) {
+	shutdown, err := Setup(context.Background(), tracingConfig(ExporterOTLP, 1))
+	require.NoError(t, err)
+	require.IsType(t, &sdktrace.TracerProvider{}, otel.GetTracerProvider())
+	// nothing was recorded, so shutdown doesn't need the collector
+	require.NoError(t, shutdown(context.Background()))
+}
+
+func TestSetup_UnknownExporter(t *testing.T) {
+	_, err := Setup(context.Background(), tracingConfig("jaeger", 1))
+	require.ErrorContains(t, err, "unknown tracing exporter: jaeger")
+}
+
+func TestSetup_SampleRatio(t *testing.T) {
+	var buf bytes.Buffer
+	shutdown, err := Setup(context.Background(), tracingConfig(ExporterStdout, 0), WithWriter(&buf))
+	require.NoError(t, err)
+
+	ctx, root := otel.Tracer("test").Start(context.Background(), "root")
+	require.False(t, root.SpanContext().IsSampled())
+	root.End()
+
+	// a sampled remote parent is followed regardless of the ratio
+	sampled := root.SpanContext().WithTraceFlags(root.SpanContext().TraceFlags().WithSampled(true)).WithRemote(true)
+	_, child := otel.Tracer("test").Start(trace.ContextWithRemoteSpanContext(ctx, sampled), "child")
+	require.True(t, child.SpanContext().IsSampled())
+	child.End()
+
+	require.NoError(t, shutdown(context.Background()))
+	require.NotContains(t, buf.String(), `"Name":"root"`)
+	require.Contains(t, buf.String(), `"Name":"child"`)
+}
@@ -0,0 +1,196 @@
+package warmup
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/esavich/otus_project/internal/resizer"
+)
+
+// MaxItems limits urls × sizes of one warm-up.
+const MaxItems = 10000
+
+type ImageGetter interface {
+	GetResizedImage(ctx context.Context, width, height int, imgURL string, header http.Header) (image.Image, error)
+}
+
+// Request lists images to warm up, every url is resized to every size.
+type Request struct {
+	URLs []string `json:"urls"`
+	// WIDTHxHEIGHT
+	Sizes []string `json:"sizes"`
+	// images processed at once, capped by the caller
+	Concurrency int `json:"concurrency,omitempty"`
+}
+
+type Item struct {
+	URL    string
+	Width  int
+	Height int
+}
+
+type Result struct {
+	URL      string `json:"url"`
+	Size     string `json:"size"`
+	Duration string `json:"duration,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Total     int      `json:"total"`
+	Succeeded int      `json:"succeeded"`
+	Failed    int      `json:"failed"`
+	Duration  string   `json:"duration"`
+	Results   []Result `json:"results"`
+}
+
+// Items validates the request and returns every url × size.
+func (r Request) Items() ([]Item, error) {
+	if len(r.URLs) == 0 || len(r.Sizes) == 0 {
+		return nil, errors.New("urls and sizes are required")
+	}
+	if len(r.URLs)*len(r.Sizes) > MaxItems {
+		return nil, fmt.Errorf("too many images: %d, at most %d", len(r.URLs)*len(r.Sizes), MaxItems)
+	}
+
+	var errs []error
+	for _, u := range r.URLs {
+		if err := checkURL(u); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	sizes := make([][2]int, 0, len(r.Sizes))
+	for _, size := range r.Sizes {
+		w, h, err := resizer.ParseSize(size)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		sizes = append(sizes, [2]int{w, h})
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	items := make([]Item, 0, len(r.URLs)*len(sizes))
+	for _, u := range r.URLs {
+		for _, size := range sizes {
+			items = append(items, Item{URL: u, Width: size[0], Height: size[1]})
+		}
+	}
+
+	return items, nil
+}
+
+// checkURL accepts the urls the resize handler serves, so the cache keys match.
+func checkURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url %q, expected an absolute http or https url", value)
+	}
+	// the resize route has no place for them, such images are never requested from the cache
+	if u.RawQuery != "" || u.ForceQuery || u.Fragment != "" || strings.Contains(value, "#") {
+		return fmt.Errorf("invalid url %q, query and fragment are not supported", value)
+	}
+	lower := strings.ToLower(u.Path)
+	if !strings.HasSuffix(lower, ".jpg") && !strings.HasSuffix(lower, ".jpeg") {
+		return fmt.Errorf("invalid url %q, not jpeg", value)
+	}
+
+	return nil
+}
+
+// ReadFile reads a request from a json file, or from a text file with one url per line.
+// Empty lines and lines starting with # are skipped.
+func ReadFile(path string) (Request, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Request{}, err
+	}
+	defer f.Close()
+
+	var req Request
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		if err := json.NewDecoder(f).Decode(&req); err != nil {
+			return Request{}, fmt.Errorf("invalid json: %w", err)
+		}
+		return req, nil
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			req.URLs = append(req.URLs, line)
+		}
+	}
+
+	return req, scanner.Err()
+}
+
+// Run gets every item through the getter with at most concurrency at once
+// and reports each of them. Items not started before ctx is done fail.
+func Run(ctx context.Context, getter ImageGetter, items []Item, concurrency int) Report {
+	start := time.Now()
+	results := make([]Result, len(items))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(max(concurrency, 1))
+	for range max(concurrency, 1) {
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = warm(ctx, getter, items[i])
+			}
+		}()
+	}
+	for i := range items {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	report := Report{
+		Total:    len(items),
+		Duration: time.Since(start).String(),
+		Results:  results,
+	}
+	for _, result := range results {
+		if result.Error != "" {
+			report.Failed++
+		} else {
+			report.Succeeded++
+		}
+	}
+
+	return report
+}
+
+func warm(ctx context.Context, getter ImageGetter, item Item) Result {
+	start := time.Now()
+	result := Result{URL: item.URL, Size: fmt.Sprintf("%dx%d", item.Width, item.Height)}
+	if err := ctx.Err(); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	// no client headers, so the images land in the partition of anonymous requests
+	_, err := getter.GetResizedImage(ctx, item.Width, item.Height, item.URL, http.Header{})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Duration = time.Since(start).String()
+
+	return result
+}
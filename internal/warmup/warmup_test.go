@@ -0,0 +1,129 @@
+package warmup
+
+import (
+	"context"
+	"errors"
+	"image"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeGetter struct {
+	mutex   sync.Mutex
+	calls   []Item
+	running atomic.Int32
+	peak    atomic.Int32
+}
+
+func (g *fakeGetter) GetResizedImage(
+	_ context.Context,
+	width, height int,
+	imgURL string,
+	_ http.Header,
+) (image.Image, error) {
+	running := g.running.Add(1)
+	defer g.running.Add(-1)
+	for {
+		peak := g.peak.Load()
+		if running <= peak || g.peak.CompareAndSwap(peak, running) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	g.mutex.Lock()
+	g.calls = append(g.calls, Item{URL: imgURL, Width: width, Height: height})
+	g.mutex.Unlock()
+
+	if filepath.Base(imgURL) == "missing.jpg" {
+		return nil, errors.New("not found")
+	}
+	return image.NewRGBA(image.Rect(0, 0, width, height)), nil
+}
+
+func TestRequest_Items(t *testing.T) {
+	req := Request{
+		URLs:  []string{"http://example.com/a.jpg", "https://example.com/b.JPEG"},
+		Sizes: []string{"100x100", "200x50"},
+	}
+	items, err := req.Items()
+	require.NoError(t, err)
+	require.Equal(t, []Item{
+		{URL: "http://example.com/a.jpg", Width: 100, Height: 100},
+		{URL: "http://example.com/a.jpg", Width: 200, Height: 50},
+		{URL: "https://example.com/b.JPEG", Width: 100, Height: 100},
+		{URL: "https://example.com/b.JPEG", Width: 200, Height: 50},
+	}, items)
+
+	invalid := []Request{
+		{},
+		{URLs: []string{"http://example.com/a.jpg"}},
+		{URLs: []string{"example.com/a.jpg"}, Sizes: []string{"1x1"}},
+		{URLs: []string{"http://example.com/a.png"}, Sizes: []string{"1x1"}},
+		{URLs: []string{"http://example.com/a.jpg?w=1"}, Sizes: []string{"1x1"}},
+		{URLs: []string{"http://example.com/a.jpg?"}, Sizes: []string{"1x1"}},
+		{URLs: []string{"http://example.com/a.jpg#top"}, Sizes: []string{"1x1"}},
+		{URLs: []string{"http://example.com/a.jpg"}, Sizes: []string{"0x1"}},
+		{URLs: make([]string, MaxItems+1), Sizes: []string{"1x1"}},
+	}
+	for _, req := range invalid {
+		_, err := req.Items()
+		require.Error(t, err, req)
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	dir := t.TempDir()
+	text := filepath.Join(dir, "urls.txt")
+	content := "http://example.com/a.jpg\n\n# comment\n  http://example.com/b.jpg  \n"
+	require.NoError(t, os.WriteFile(text, []byte(content), 0o600))
+	req, err := ReadFile(text)
+	require.NoError(t, err)
+	require.Equal(t, Request{URLs: []string{"http://example.com/a.jpg", "http://example.com/b.jpg"}}, req)
+
+	data := `{"urls":["http://example.com/a.jpg"],"sizes":["10x10"],"concurrency":2}`
+	jsonFile := filepath.Join(dir, "warmup.json")
+	require.NoError(t, os.WriteFile(jsonFile, []byte(data), 0o600))
+	req, err = ReadFile(jsonFile)
+	require.NoError(t, err)
+	require.Equal(t, Request{URLs: []string{"http://example.com/a.jpg"}, Sizes: []string{"10x10"}, Concurrency: 2}, req)
+}
+
+func TestRun(t *testing.T) {
+	req := Request{
+		URLs:  []string{"http://example.com/a.jpg", "http://example.com/missing.jpg", "http://example.com/b.jpg"},
+		Sizes: []string{"10x10", "20x20"},
+	}
+	items, err := req.Items()
+	require.NoError(t, err)
+
+	getter := &fakeGetter{}
+	report := Run(context.Background(), getter, items, 2)
+	require.Equal(t, 6, report.Total)
+	require.Equal(t, 4, report.Succeeded)
+	require.Equal(t, 2, report.Failed)
+	require.LessOrEqual(t, getter.peak.Load(), int32(2))
+	require.ElementsMatch(t, items, getter.calls)
+
+	// results are in the order of items
+	require.Equal(t, Result{URL: "http://example.com/missing.jpg", Size: "20x20", Error: "not found"}, report.Results[3])
+	require.Equal(t, "http://example.com/b.jpg", report.Results[4].URL)
+	require.Empty(t, report.Results[4].Error)
+}
+
+func TestRun_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	getter := &fakeGetter{}
+	report := Run(ctx, getter, []Item{{URL: "http://example.com/a.jpg", Width: 1, Height: 1}}, 1)
+	require.Equal(t, 1, report.Failed)
+	require.Empty(t, getter.calls)
+}
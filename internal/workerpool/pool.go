@@ -0,0 +1,99 @@
+package workerpool
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+)
+
+var (
+	// ErrQueueFull is returned when the pool can't accept more jobs, callers should fail fast.
+	ErrQueueFull = errors.New("worker queue is full")
+	// ErrClosed is returned for jobs of requests still running after the pool was closed on shutdown.
+	ErrClosed = errors.New("worker pool is closed")
+)
+
+// Pool runs jobs on a fixed number of workers with a bounded queue.
+type Pool struct {
+	jobs chan func()
+	wg   sync.WaitGroup
+	// mutex guards closed, jobs are queued under the read lock so the channel is not closed meanwhile
+	mutex  sync.RWMutex
+	closed bool
+}
+
+// New starts the pool, workers defaults to the number of CPUs when not positive.
+func New(workers, queueSize int) *Pool {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	p := &Pool{
+		jobs: make(chan func(), queueSize),
+	}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.worker()
+	}
+
+	return p
+}
+
+func (p *Pool) worker() {
+	defer p.wg.Done()
+	for job := range p.jobs {
+		job()
+	}
+}
+
+// Do queues fn and waits until it is done. It returns ErrQueueFull immediately if the queue is full
+// and ErrClosed after Close.
+func (p *Pool) Do(fn func()) error {
+	done := make(chan struct{})
+	job := func() {
+		defer close(done)
+		fn()
+	}
+
+	if err := p.queue(job); err != nil {
+		return err
+	}
+	<-done
+
+	return nil
+}
+
+func (p *Pool) queue(job func()) error {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if p.closed {
+		return ErrClosed
+	}
+	select {
+	case p.jobs <- job:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// QueueDepth returns the number of jobs waiting for a worker.
+func (p *Pool) QueueDepth() int {
+	return len(p.jobs)
+}
+
+// Close stops accepting jobs and waits for queued ones to finish. It may be called more than once.
+func (p *Pool) Close() {
+	p.mutex.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+	p.mutex.Unlock()
+
+	p.wg.Wait()
+}
@@ -0,0 +1,74 @@
+package workerpool
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPool_Do(t *testing.T) {
+	p := New(2, 10)
+	defer p.Close()
+
+	var counter atomic.Int32
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := p.Do(func() { counter.Add(1) })
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, int32(10), counter.Load())
+}
+
+func TestPool_QueueFull(t *testing.T) {
+	p := New(1, 1)
+	defer p.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	wg := sync.WaitGroup{}
+
+	// occupy the only worker
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		p.Do(func() {
+			close(started)
+			<-release
+		})
+	}()
+	<-started
+
+	// fill the queue
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		p.Do(func() {})
+	}()
+	require.Eventually(t, func() bool { return p.QueueDepth() == 1 }, time.Second, time.Millisecond)
+
+	err := p.Do(func() {})
+	require.ErrorIs(t, err, ErrQueueFull)
+
+	close(release)
+	wg.Wait()
+	require.Equal(t, 0, p.QueueDepth())
+}
+
+func TestPool_DoAfterClose(t *testing.T) {
+	p := New(1, 1)
+	require.NoError(t, p.Do(func() {}))
+	p.Close()
+
+	require.ErrorIs(t, p.Do(func() { t.Error("job after close must not run") }), ErrClosed)
+	p.Close()
+}
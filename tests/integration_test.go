@@ -20,6 +20,7 @@ import (
 	"github.com/esavich/otus_project/internal/downloader"
 	"github.com/esavich/otus_project/internal/resizer"
 	"github.com/esavich/otus_project/internal/service"
+	"github.com/esavich/otus_project/internal/workerpool"
 )
 
 // from https://golang.testcontainers.org/examples/nginx/
@@ -81,6 +82,7 @@ func TestCachedService(t *testing.T) {
 	imageService := service.NewSimpleImageService(
 		downloader.NewDownloader(5*time.Second),
 		resizer.NewResizer(),
+		workerpool.New(2, 10),
 	)
 	dir := t.TempDir()
 	dc, err := diskcache.NewDiskCacheWrapper(3, dir)
@@ -96,7 +98,7 @@ func TestCachedService(t *testing.T) {
 
 	t.Run("invalid url", func(t *testing.T) {
 		imgURL := "invalid"
-		result, err := cachedService.GetResizedImage(50, 60, imgURL, headers)
+		result, err := cachedService.GetResizedImage(ctx, 50, 60, imgURL, headers)
 
 		require.Error(t, err)
 		require.ErrorContains(t, err, "cant do request:")
@@ -105,7 +107,7 @@ func TestCachedService(t *testing.T) {
 
 	t.Run("success", func(t *testing.T) {
 		imgURL := nginxC.URI + "/examples/gopher.jpg"
-		result, err := cachedService.GetResizedImage(50, 60, imgURL, headers)
+		result, err := cachedService.GetResizedImage(ctx, 50, 60, imgURL, headers)
 
 		require.NoError(t, err)
 		require.NotNil(t, result)
@@ -113,7 +115,7 @@ func TestCachedService(t *testing.T) {
 
 	t.Run("404", func(t *testing.T) {
 		imgURL := nginxC.URI + "/examples/gopher-404.jpg"
-		result, err := cachedService.GetResizedImage(50, 60, imgURL, headers)
+		result, err := cachedService.GetResizedImage(ctx, 50, 60, imgURL, headers)
 
 		require.Error(t, err)
 		require.ErrorContains(t, err, "invalid status: 404 Not Found")
@@ -122,7 +124,7 @@ func TestCachedService(t *testing.T) {
 
 	t.Run("not image", func(t *testing.T) {
 		imgURL := nginxC.URI + "/examples/1.txt"
-		result, err := cachedService.GetResizedImage(50, 60, imgURL, headers)
+		result, err := cachedService.GetResizedImage(ctx, 50, 60, imgURL, headers)
 
 		require.Error(t, err)
 		require.ErrorContains(t, err, "cant decode jpeg")
@@ -131,7 +133,7 @@ func TestCachedService(t *testing.T) {
 
 	t.Run("broken image", func(t *testing.T) {
 		imgURL := nginxC.URI + "/examples/bad.jpg"
-		result, err := cachedService.GetResizedImage(50, 60, imgURL, headers)
+		result, err := cachedService.GetResizedImage(ctx, 50, 60, imgURL, headers)
 
 		require.Error(t, err)
 		require.ErrorContains(t, err, "cant decode jpeg")
@@ -150,7 +152,7 @@ func TestCachedService(t *testing.T) {
 		defer slog.SetDefault(oldLogger)
 
 		// cache miss
-		_, err := cachedService.GetResizedImage(220, 300, imgURL, headers)
+		_, err := cachedService.GetResizedImage(ctx, 220, 300, imgURL, headers)
 		require.NoError(t, err)
 		require.Contains(t, logBuf.String(), "downloading")
 
@@ -160,7 +162,7 @@ func TestCachedService(t *testing.T) {
 			logBuf.Reset()
 
 			// must be from cache
-			_, err = cachedService.GetResizedImage(220, 300, imgURL, headers)
+			_, err = cachedService.GetResizedImage(ctx, 220, 300, imgURL, headers)
 			require.NoError(t, err)
 
 			// check cache hit